@@ -0,0 +1,275 @@
+package mellivora
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+const (
+	defaultBulkInsertBatchSize  = 1000
+	defaultBulkInsertBatchBytes = 4 << 20
+)
+
+// BulkInsertOptions controls how BulkInsert splits rows into transactions.
+type BulkInsertOptions struct {
+	// BatchSize is the maximum number of rows inserted by each transaction, it defaults to 1000.
+	BatchSize int
+
+	// BatchBytes is the maximum size of the keys and values written by each transaction, it
+	// defaults to 4MB which keeps transactions well under the limits of the store. A row that is
+	// larger than this is inserted by a transaction of its own.
+	BatchBytes int
+
+	// Concurrency is the number of transactions that are written at the same time, it defaults
+	// to 1.
+	Concurrency int
+
+	// Progress is called after each transaction is finished with the number of rows that have been
+	// inserted and the number that have failed so far. Calls are never made concurrently.
+	Progress func(inserted, failed, total int)
+}
+
+type bulkRow struct {
+	index int
+	model interface{}
+	key   string
+
+	// size is the size of the keys and values of the row.
+	size int
+}
+
+// BulkInsert inserts a slice of rows using as many transactions as needed to keep each of them
+// under the batch size and batch bytes. Rows are sorted by their primary key before they are split
+// up so that each transaction writes a narrow range of keys. Rows with the same primary key or
+// unique values as an earlier row are rejected before anything is written. Each row is checked
+// before it is written, and rows that fail are skipped without failing the rest of their
+// transaction. Transactions that conflict are retried like RunInTransaction. If any rows are not
+// inserted then a BulkInsertErrors is returned with the error for each of them.
+func (db *Database) BulkInsert(rows interface{}, opts BulkInsertOptions) error {
+	info, err := db.getModel(rows)
+	if err != nil {
+		return err
+	}
+
+	value := reflect.ValueOf(rows)
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return fmt.Errorf("cannot bulk insert %T, it must be a slice or an array", rows)
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBulkInsertBatchSize
+	}
+
+	if opts.BatchBytes <= 0 {
+		opts.BatchBytes = defaultBulkInsertBatchBytes
+	}
+
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+
+	pending, failures := db.prepareBulkRows(info, value)
+
+	batches := make(chan []bulkRow)
+	go func() {
+		defer close(batches)
+		start, size := 0, 0
+		for i, row := range pending {
+			if i > start && (i-start >= opts.BatchSize || size+row.size > opts.BatchBytes) {
+				batches <- pending[start:i]
+				start, size = i, 0
+			}
+			size += row.size
+		}
+
+		if start < len(pending) {
+			batches <- pending[start:]
+		}
+	}()
+
+	var lock sync.Mutex
+	var wait sync.WaitGroup
+	inserted, total := 0, value.Len()
+	for i := 0; i < opts.Concurrency; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for batch := range batches {
+				count, batchFailures := db.insertBatch(batch)
+
+				lock.Lock()
+				inserted += count
+				failures = append(failures, batchFailures...)
+				if opts.Progress != nil {
+					opts.Progress(inserted, len(failures), total)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wait.Wait()
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Row < failures[j].Row
+	})
+
+	return BulkInsertErrors(failures)
+}
+
+// prepareBulkRows builds the keys of every row to find rows that have the same primary key or
+// unique values as an earlier row. The remaining rows are returned sorted by their primary key.
+// The keys are built before the BeforeInsert hooks of the rows are run, so hooks should not change
+// the primary key or unique fields of a row. A row whose hook does is still verified against the
+// stored keys when it is written, but a duplicate is then reported as a *PrimaryKeyViolation or
+// *UniqueViolation rather than a *BatchDuplicate.
+func (db *Database) prepareBulkRows(info Model, value reflect.Value) ([]bulkRow, []*RowError) {
+	rows := make([]bulkRow, 0, value.Len())
+	failures := make([]*RowError, 0)
+	owners := map[string]int{}
+	for i := 0; i < value.Len(); i++ {
+		row := value.Index(i)
+		model := row.Interface()
+		if row.Kind() != reflect.Ptr && row.CanAddr() {
+			model = row.Addr().Interface()
+		}
+
+		// The keys are built from a copy so that defaults and timestamps are only set on the row
+		// when it is inserted.
+		for row.Kind() == reflect.Ptr {
+			row = row.Elem()
+		}
+		copied := reflect.New(row.Type()).Elem()
+		copied.Set(row)
+
+		builder := newDatumBuilder(info, copied, true)
+		datums, err := builder.Keys()
+		if err != nil {
+			failures = append(failures, &RowError{Row: i, Err: err})
+			continue
+		}
+
+		datumKey, size := "", 0
+		var duplicate error
+		for _, key := range sortedKeys(datums) {
+			size += len(key) + len(datums[key])
+			switch key[0] {
+			case datumKeyPrefix:
+				datumKey = key
+			case indexKeyPrefix:
+				continue
+			}
+
+			if owner, ok := owners[key]; ok && duplicate == nil {
+				duplicate = builder.(*datumBuilderBase).duplicateOf(key, owner, i)
+			}
+		}
+
+		if duplicate != nil {
+			failures = append(failures, &RowError{Row: i, Err: duplicate})
+			continue
+		}
+
+		for key := range datums {
+			if key[0] != indexKeyPrefix {
+				owners[key] = i
+			}
+		}
+
+		rows = append(rows, bulkRow{
+			index: i,
+			model: model,
+			key:   datumKey,
+			size:  size,
+		})
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].key < rows[j].key
+	})
+
+	return rows, failures
+}
+
+// insertBatch inserts the rows in a single transaction. Each row is checked before it is written so
+// rows that cannot be inserted are skipped without affecting the rest of the batch. If one of the
+// AfterInsert hooks of a row returns an error after the row has been written then the transaction
+// is rolled back and the batch is retried without that row. If the store fails to write a row,
+// like when the transaction has grown too large, then the row is not to blame and the batch is
+// split in half and each half is inserted on its own. A row that the store cannot write by itself
+// fails with the error of the store.
+func (db *Database) insertBatch(rows []bulkRow) (int, []*RowError) {
+	failures := make([]*RowError, 0)
+	for {
+		var skipped []*RowError
+		var failed *RowError
+		var storeErr error
+		err := db.RunInTransaction(context.Background(), func(txn *Transaction) error {
+			skipped, failed, storeErr = make([]*RowError, 0), nil, nil
+			for _, row := range rows {
+				pending, err := txn.prepareInsert(row.model)
+				if err != nil {
+					skipped = append(skipped, &RowError{Row: row.index, Err: err})
+					continue
+				}
+
+				if err := txn.writeInsert(pending); err != nil {
+					storeErr = err
+					return storeErr
+				}
+
+				if err := txn.runHooks(hookAfterInsert, pending.records); err != nil {
+					failed = &RowError{Row: row.index, Err: err}
+					return failed
+				}
+			}
+
+			return nil
+		})
+
+		switch {
+		case err == nil:
+			return len(rows) - len(skipped), append(failures, skipped...)
+		case failed != nil && err == failed:
+			failures = append(failures, failed)
+			remaining := make([]bulkRow, 0, len(rows)-1)
+			for _, row := range rows {
+				if row.index != failed.Row {
+					remaining = append(remaining, row)
+				}
+			}
+			rows = remaining
+		case storeErr != nil && err == storeErr && len(rows) > 1:
+			half := len(rows) / 2
+			firstCount, firstFailures := db.insertBatch(rows[:half])
+			secondCount, secondFailures := db.insertBatch(rows[half:])
+			failures = append(append(failures, firstFailures...), secondFailures...)
+			return firstCount + secondCount, failures
+		default:
+			// Rows that were skipped keep their own error rather than the error of the batch.
+			skippedRows := make(map[int]bool, len(skipped))
+			for _, skippedErr := range skipped {
+				skippedRows[skippedErr.Row] = true
+			}
+
+			failures = append(failures, skipped...)
+			for _, row := range rows {
+				if !skippedRows[row.index] {
+					failures = append(failures, &RowError{Row: row.index, Err: err})
+				}
+			}
+
+			return 0, failures
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package mellivora
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testBulkJob struct {
+	JobId uint64 `m:"pk"`
+	Name  string
+
+	// conflict makes the first BeforeInsert hook of the job insert a job with JobId 1 in another
+	// transaction, so that the transaction inserting the job conflicts.
+	conflict bool `m:"-"`
+
+	// failAfter makes the AfterInsert hook of the job return an error.
+	failAfter bool `m:"-"`
+
+	// renumber makes the BeforeInsert hook of the job change its primary key.
+	renumber uint64 `m:"-"`
+
+	attempts int `m:"-"`
+}
+
+func (j *testBulkJob) BeforeInsert(txn *Transaction) error {
+	j.attempts++
+	if j.renumber != 0 {
+		j.JobId = j.renumber
+	}
+
+	if !j.conflict || j.attempts > 1 {
+		return nil
+	}
+
+	other, err := txn.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := other.Insert(testBulkJob{JobId: 1, Name: "other"}); err != nil {
+		_ = other.Rollback()
+		return err
+	}
+
+	return other.Commit()
+}
+
+func (j *testBulkJob) AfterInsert(txn *Transaction) error {
+	if j.failAfter {
+		return fmt.Errorf("job %d failed after insert", j.JobId)
+	}
+
+	return nil
+}
+
+func TestDatabase_BulkInsert(t *testing.T) {
+	type Device struct {
+		DeviceId uint64 `m:"pk"`
+		Serial   string `m:"uq,notempty"`
+		Region   string `m:"index,default:east"`
+	}
+
+	t.Run("batches", func(t *testing.T) {
+		db, cleanup := NewTestDatabase(t)
+		defer cleanup()
+
+		devices := make([]Device, 25)
+		for i := range devices {
+			// The rows are inserted in reverse so they need to be sorted.
+			devices[i] = Device{
+				DeviceId: uint64(len(devices) - i),
+				Serial:   fmt.Sprintf("serial-%d", i),
+			}
+		}
+
+		calls, lastInserted := 0, 0
+		err := db.BulkInsert(devices, BulkInsertOptions{
+			BatchSize:   4,
+			Concurrency: 3,
+			Progress: func(inserted, failed, total int) {
+				calls++
+				assert.True(t, inserted > lastInserted, "progress should increase")
+				assert.Equal(t, 0, failed)
+				assert.Equal(t, 25, total)
+				lastInserted = inserted
+			},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 7, calls)
+		assert.Equal(t, 25, lastInserted)
+		assert.Equal(t, "east", devices[0].Region, "defaults should be set on the rows")
+
+		txn, err := db.Begin()
+		assert.NoError(t, err)
+		defer txn.Rollback()
+
+		results := make([]Device, 0)
+		err = txn.Model(results).Select(&results)
+		assert.NoError(t, err)
+		assert.Len(t, results, 25)
+	})
+
+	t.Run("row failures", func(t *testing.T) {
+		db, cleanup := NewTestDatabase(t)
+		defer cleanup()
+
+		txn, err := db.Begin()
+		assert.NoError(t, err)
+		assert.NoError(t, txn.Insert(Device{DeviceId: 3, Serial: "existing"}))
+		assert.NoError(t, txn.Commit())
+
+		err = db.BulkInsert([]Device{
+			{DeviceId: 1, Serial: "one"},
+			{DeviceId: 2, Serial: "two"},
+			{DeviceId: 3, Serial: "three"},
+			{DeviceId: 4, Serial: ""},
+			{DeviceId: 5, Serial: "two"},
+			{DeviceId: 6, Serial: "six"},
+		}, BulkInsertOptions{
+			BatchSize: 2,
+		})
+
+		var bulkErrs BulkInsertErrors
+		if assert.True(t, errors.As(err, &bulkErrs)) && assert.Len(t, bulkErrs, 3) {
+			assert.Equal(t, 2, bulkErrs[0].Row)
+			var primaryKeyViolation *PrimaryKeyViolation
+			assert.True(t, errors.As(bulkErrs[0], &primaryKeyViolation))
+
+			assert.Equal(t, 3, bulkErrs[1].Row)
+			assert.EqualError(t, bulkErrs[1].Err, "Device field [Serial] must not be empty")
+
+			assert.Equal(t, 4, bulkErrs[2].Row)
+			assert.Equal(t, &BatchDuplicate{
+				Model:      "Device",
+				Constraint: "uq_serial",
+				Rows:       []int{1, 4},
+				Values:     []interface{}{"two"},
+			}, bulkErrs[2].Err)
+		}
+
+		txn, err = db.Begin()
+		assert.NoError(t, err)
+		defer txn.Rollback()
+
+		results := make([]Device, 0)
+		err = txn.Model(results).OrderBy("DeviceId").Select(&results)
+		assert.NoError(t, err)
+		serials := make([]string, len(results))
+		for i, result := range results {
+			serials[i] = result.Serial
+		}
+		assert.Equal(t, []string{"one", "two", "existing", "six"}, serials)
+	})
+
+	t.Run("batch bytes", func(t *testing.T) {
+		db, cleanup := NewTestDatabase(t)
+		defer cleanup()
+
+		devices := make([]Device, 10)
+		for i := range devices {
+			devices[i] = Device{
+				DeviceId: uint64(i + 1),
+				Serial:   fmt.Sprintf("serial-%d", i),
+			}
+		}
+
+		// Every row is larger than the limit so each one is inserted by its own transaction.
+		calls := 0
+		err := db.BulkInsert(devices, BulkInsertOptions{
+			BatchBytes: 1,
+			Progress: func(inserted, failed, total int) {
+				calls++
+				assert.Equal(t, calls, inserted)
+			},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 10, calls)
+	})
+
+	getJobs := func(t *testing.T, db *Database) []string {
+		txn, err := db.Begin()
+		assert.NoError(t, err)
+		defer txn.Rollback()
+
+		jobs := make([]testBulkJob, 0)
+		err = txn.Model(jobs).OrderBy("JobId").Select(&jobs)
+		assert.NoError(t, err)
+		names := make([]string, len(jobs))
+		for i, job := range jobs {
+			names[i] = job.Name
+		}
+
+		return names
+	}
+
+	t.Run("conflict", func(t *testing.T) {
+		db, cleanup := NewTestDatabase(t)
+		defer cleanup()
+
+		jobs := []testBulkJob{
+			{JobId: 1, Name: "one"},
+			{JobId: 2, Name: "two", conflict: true},
+			{JobId: 3, Name: "three"},
+		}
+		err := db.BulkInsert(jobs, BulkInsertOptions{})
+
+		// The batch is retried after the conflict, and the first job now has the same primary key
+		// as the job inserted by the other transaction.
+		var bulkErrs BulkInsertErrors
+		if assert.True(t, errors.As(err, &bulkErrs)) && assert.Len(t, bulkErrs, 1) {
+			assert.Equal(t, 0, bulkErrs[0].Row)
+			var primaryKeyViolation *PrimaryKeyViolation
+			assert.True(t, errors.As(bulkErrs[0], &primaryKeyViolation))
+		}
+		assert.Equal(t, 2, jobs[1].attempts)
+		assert.Equal(t, []string{"other", "two", "three"}, getJobs(t, db))
+	})
+
+	t.Run("after insert hook", func(t *testing.T) {
+		db, cleanup := NewTestDatabase(t)
+		defer cleanup()
+
+		err := db.BulkInsert([]testBulkJob{
+			{JobId: 1, Name: "one"},
+			{JobId: 2, Name: "two", failAfter: true},
+			{JobId: 3, Name: "three"},
+		}, BulkInsertOptions{})
+
+		var bulkErrs BulkInsertErrors
+		if assert.True(t, errors.As(err, &bulkErrs)) && assert.Len(t, bulkErrs, 1) {
+			assert.Equal(t, 1, bulkErrs[0].Row)
+			assert.EqualError(t, bulkErrs[0].Err, "job 2 failed after insert")
+		}
+		assert.Equal(t, []string{"one", "three"}, getJobs(t, db))
+	})
+
+	t.Run("hook changes key", func(t *testing.T) {
+		db, cleanup := NewTestDatabase(t)
+		defer cleanup()
+
+		// The duplicate is not known until the hook runs, so it is found when the job is written.
+		err := db.BulkInsert([]testBulkJob{
+			{JobId: 1, Name: "one"},
+			{JobId: 2, Name: "two", renumber: 1},
+		}, BulkInsertOptions{})
+
+		var bulkErrs BulkInsertErrors
+		if assert.True(t, errors.As(err, &bulkErrs)) && assert.Len(t, bulkErrs, 1) {
+			assert.Equal(t, 1, bulkErrs[0].Row)
+			var primaryKeyViolation *PrimaryKeyViolation
+			assert.True(t, errors.As(bulkErrs[0], &primaryKeyViolation))
+		}
+		assert.Equal(t, []string{"one"}, getJobs(t, db))
+	})
+
+	t.Run("not a slice", func(t *testing.T) {
+		db := &Database{}
+		err := db.BulkInsert(Device{}, BulkInsertOptions{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,99 @@
+package mellivora
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// checkMethodName is the name of the constraint reported when the Check method of a model fails.
+const checkMethodName = "Check"
+
+type (
+	// Checker is implemented by models that need to check a record as a whole before it is
+	// inserted or updated. Returning an error prevents the record from being written, the error is
+	// returned as a *CheckViolation unless it already is one.
+	Checker interface {
+		Check() error
+	}
+
+	// CheckFunc is a check constraint registered with Database.RegisterCheck. It is passed a
+	// pointer to the record being written and returns false if the record violates the constraint.
+	CheckFunc func(record interface{}) bool
+
+	checkConstraint struct {
+		name  string
+		check CheckFunc
+	}
+)
+
+// RegisterCheck adds a named check constraint to the provided model. The check is evaluated every
+// time a record of the model is inserted or updated in this database, after any validation tags.
+func (db *Database) RegisterCheck(model interface{}, name string, check CheckFunc) error {
+	info, err := db.getModel(model)
+	if err != nil {
+		return err
+	}
+
+	if len(name) == 0 {
+		return fmt.Errorf("check constraints on %s must have a name", info.Name())
+	} else if name == checkMethodName {
+		return fmt.Errorf("check constraint name [%s] is reserved for the Check method", name)
+	}
+
+	db.modelsLock.Lock()
+	defer db.modelsLock.Unlock()
+	if db.checks == nil {
+		db.checks = map[reflect.Type][]checkConstraint{}
+	}
+
+	for _, existing := range db.checks[info.Type()] {
+		if existing.name == name {
+			return fmt.Errorf("%s already has a check constraint named [%s]", info.Name(), name)
+		}
+	}
+
+	db.checks[info.Type()] = append(db.checks[info.Type()], checkConstraint{
+		name:  name,
+		check: check,
+	})
+
+	return nil
+}
+
+// checkRecord evaluates the Check method of the record, if it has one, and then each of the check
+// constraints registered for the model. The first constraint that is violated is returned.
+func (db *Database) checkRecord(info Model, record reflect.Value) error {
+	pointer := record.Addr().Interface()
+	if checker, ok := pointer.(Checker); ok {
+		if err := checker.Check(); err != nil {
+			violation, ok := err.(*CheckViolation)
+			if !ok {
+				violation = &CheckViolation{
+					Constraint: checkMethodName,
+					Err:        err,
+				}
+			}
+
+			if len(violation.Model) == 0 {
+				violation.Model = info.Name()
+			}
+
+			return violation
+		}
+	}
+
+	db.modelsLock.RLock()
+	checks := db.checks[info.Type()]
+	db.modelsLock.RUnlock()
+
+	for _, constraint := range checks {
+		if !constraint.check(pointer) {
+			return &CheckViolation{
+				Model:      info.Name(),
+				Constraint: constraint.name,
+			}
+		}
+	}
+
+	return nil
+}
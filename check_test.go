@@ -0,0 +1,119 @@
+package mellivora
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testCheckRange struct {
+	RangeId uint64 `m:"pk"`
+	ShardId uint64
+	Start   int
+	End     int
+	Frozen  bool
+}
+
+func (r testCheckRange) Check() error {
+	if r.End < r.Start {
+		return fmt.Errorf("end %d is before start %d", r.End, r.Start)
+	}
+
+	if r.Start < 0 {
+		return &CheckViolation{Constraint: "ck_start_positive"}
+	}
+
+	return nil
+}
+
+type testCheckPlacement struct {
+	PlacementId uint64 `m:"pk"`
+	ShardId     uint64 `m:"readonly"`
+	Pinned      bool
+}
+
+func (p testCheckPlacement) Check() error {
+	if p.Pinned && p.ShardId == 0 {
+		return fmt.Errorf("placement %d is pinned without a shard", p.PlacementId)
+	}
+
+	return nil
+}
+
+func TestDatabase_RegisterCheck(t *testing.T) {
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	err := db.RegisterCheck(testCheckRange{}, "ck_frozen_shard", func(record interface{}) bool {
+		r := record.(*testCheckRange)
+		return !r.Frozen || r.ShardId != 0
+	})
+	assert.NoError(t, err)
+
+	t.Run("invalid names", func(t *testing.T) {
+		err := db.RegisterCheck(testCheckRange{}, "ck_frozen_shard", func(interface{}) bool { return true })
+		assert.EqualError(t, err, "testCheckRange already has a check constraint named [ck_frozen_shard]")
+
+		err = db.RegisterCheck(testCheckRange{}, "", func(interface{}) bool { return true })
+		assert.Error(t, err)
+
+		err = db.RegisterCheck(testCheckRange{}, "Check", func(interface{}) bool { return true })
+		assert.Error(t, err)
+	})
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	t.Run("valid", func(t *testing.T) {
+		err := txn.Insert(testCheckRange{RangeId: 1, Start: 1, End: 10})
+		assert.NoError(t, err)
+	})
+
+	t.Run("check method", func(t *testing.T) {
+		err := txn.Insert(testCheckRange{RangeId: 2, Start: 10, End: 1})
+		assert.EqualError(t, err, "testCheckRange violates check constraint [Check]: end 1 is before start 10")
+
+		var violation *CheckViolation
+		if assert.True(t, errors.As(err, &violation)) {
+			assert.Equal(t, "testCheckRange", violation.Model)
+			assert.Equal(t, "Check", violation.Constraint)
+		}
+
+		err = txn.Insert(testCheckRange{RangeId: 2, Start: -1, End: 1})
+		assert.EqualError(t, err, "testCheckRange violates check constraint [ck_start_positive]")
+	})
+
+	t.Run("registered check", func(t *testing.T) {
+		err := txn.Insert(testCheckRange{RangeId: 2, Frozen: true})
+		assert.Equal(t, &CheckViolation{Model: "testCheckRange", Constraint: "ck_frozen_shard"}, err)
+
+		err = txn.Update(&testCheckRange{RangeId: 1, Start: 1, End: 10, Frozen: true})
+		assert.Equal(t, &CheckViolation{Model: "testCheckRange", Constraint: "ck_frozen_shard"}, err)
+
+		err = txn.Update(&testCheckRange{RangeId: 1, ShardId: 3, Start: 1, End: 10, Frozen: true})
+		assert.NoError(t, err)
+	})
+}
+
+func TestTransaction_UpdateCheck(t *testing.T) {
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	err = txn.Insert(testCheckPlacement{PlacementId: 1})
+	assert.NoError(t, err)
+
+	// The shard is read only, so the record that would be stored keeps the stored shard of 0.
+	err = txn.Update(&testCheckPlacement{PlacementId: 1, ShardId: 7, Pinned: true})
+	assert.EqualError(t, err, "testCheckPlacement violates check constraint [Check]: placement 1 is pinned without a shard")
+
+	result := testCheckPlacement{}
+	err = txn.Model(result).Where(Ex{"PlacementId": 1}).Select(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, testCheckPlacement{PlacementId: 1}, result)
+}
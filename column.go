@@ -0,0 +1,738 @@
+package mellivora
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"github.com/elliotcourant/buffers"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"time"
+)
+
+var (
+	_ columnCodec = &columnEncoderCodec{}
+	_ columnCodec = &binaryMarshalerCodec{}
+	_ columnCodec = &scalarCodec{}
+	_ columnCodec = &pointerCodec{}
+	_ columnCodec = &valuerCodec{}
+	_ columnCodec = &floatCodec{}
+	_ columnCodec = &timeCodec{}
+	_ columnCodec = &bigIntCodec{}
+	_ columnCodec = &bigFloatCodec{}
+	_ columnCodec = &bigRatCodec{}
+	_ columnCodec = &byteArrayCodec{}
+	_ columnCodec = &bytesCodec{}
+	_ columnCodec = &sliceCodec{}
+	_ columnCodec = &arrayCodec{}
+	_ columnCodec = &mapCodec{}
+	_ columnCodec = &structCodec{}
+	_ columnCodec = &jsonCodec{}
+)
+
+var (
+	columnEncoderType     = reflect.TypeOf((*ColumnEncoder)(nil)).Elem()
+	columnDecoderType     = reflect.TypeOf((*ColumnDecoder)(nil)).Elem()
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	valuerType            = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	scannerType           = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	bigIntType   = reflect.TypeOf(big.Int{})
+	bigFloatType = reflect.TypeOf(big.Float{})
+	bigRatType   = reflect.TypeOf(big.Rat{})
+)
+
+const (
+	nullMarker    = byte(0)
+	notNullMarker = byte(1)
+)
+
+// driverValueType is written before a value produced by a driver.Valuer so that we know how to
+// read it back before handing it to the sql.Scanner.
+type driverValueType = byte
+
+const (
+	driverValueNull = driverValueType(iota)
+	driverValueInt64
+	driverValueFloat64
+	driverValueBool
+	driverValueBytes
+	driverValueString
+	driverValueTime
+)
+
+type (
+	// ColumnEncoder can be implemented by the type of a field to control how the field is stored.
+	// The type must also implement ColumnDecoder on its pointer.
+	ColumnEncoder interface {
+		EncodeColumn() ([]byte, error)
+	}
+
+	// ColumnDecoder is implemented by the pointer of a type that implements ColumnEncoder, it is
+	// given the bytes returned by EncodeColumn when the field is read.
+	ColumnDecoder interface {
+		DecodeColumn(src []byte) error
+	}
+)
+
+type (
+	// columnCodec encodes and decodes the value of a single field in a datum.
+	columnCodec interface {
+		// Append writes the provided value to the buffer.
+		Append(buf buffers.BytesBuffer, value reflect.Value) error
+
+		// Read reads the next value from the reader and stores it in the target. The target must
+		// be settable.
+		Read(reader buffers.BytesReader, target reflect.Value) error
+
+		// AppendKey writes the provided value to the buffer for use in a key. Keys are encoded so
+		// that the bytes of two values sort the same way that the values themselves do, and so
+		// that no encoded value is a prefix of another. This allows keys made up of multiple
+		// values to be range scanned.
+		AppendKey(buf buffers.BytesBuffer, value reflect.Value) error
+
+		// ReadKey reads the next value written by AppendKey and stores it in the target.
+		ReadKey(reader buffers.BytesReader, target reflect.Value) error
+	}
+
+	// columnEncoderCodec stores types that implement ColumnEncoder and ColumnDecoder as length
+	// prefixed bytes.
+	columnEncoderCodec struct{}
+
+	// binaryMarshalerCodec stores types that implement encoding.BinaryMarshaler and
+	// encoding.BinaryUnmarshaler as length prefixed bytes.
+	binaryMarshalerCodec struct{}
+
+	// scalarCodec handles the basic kinds that the buffers package already knows how to encode.
+	scalarCodec struct{}
+
+	// pointerCodec writes a null marker before the value being pointed to so that a nil pointer
+	// can be stored and read back as nil.
+	pointerCodec struct {
+		elem columnCodec
+	}
+
+	// valuerCodec handles types like sql.NullString that implement both driver.Valuer and
+	// sql.Scanner.
+	valuerCodec struct{}
+
+	// floatCodec stores float32 and float64 values by their IEEE 754 bits.
+	floatCodec struct{}
+
+	// timeCodec stores time.Time values as seconds and nanoseconds since the unix epoch. Times are
+	// normalized to UTC, the original location is not preserved.
+	timeCodec struct{}
+
+	// bigIntCodec stores big.Int values as a sign followed by the absolute value's bytes.
+	bigIntCodec struct{}
+
+	// bigFloatCodec stores big.Float values using their gob encoding which preserves the precision
+	// and rounding mode of the value.
+	bigFloatCodec struct{}
+
+	// bigRatCodec stores big.Rat values using their gob encoding.
+	bigRatCodec struct{}
+
+	// byteArrayCodec stores fixed size byte arrays like UUIDs as is.
+	byteArrayCodec struct{}
+
+	// bytesCodec stores byte slices with a length prefix.
+	bytesCodec struct{}
+
+	// sliceCodec stores a null marker and the number of items in the slice followed by each of
+	// the items.
+	sliceCodec struct {
+		elem columnCodec
+	}
+
+	// arrayCodec stores each of the items in a fixed size array.
+	arrayCodec struct {
+		elem columnCodec
+	}
+
+	// mapCodec stores a null marker and the number of entries in the map followed by each key and
+	// value. Entries are written in the order of their encoded keys so that the same map will
+	// always produce the same bytes.
+	mapCodec struct {
+		key  columnCodec
+		elem columnCodec
+	}
+
+	// structCodec stores each of the exported fields of a nested struct in the order they are
+	// declared.
+	structCodec struct {
+		fields []structCodecField
+	}
+
+	structCodecField struct {
+		index int
+		codec columnCodec
+	}
+
+	// jsonCodec stores the value as a length prefixed JSON document. This is used for fields with
+	// the json tag.
+	jsonCodec struct{}
+)
+
+// getColumnCodec returns the codec that should be used to store a field of the provided type. If
+// the type cannot be stored then nil is returned.
+func getColumnCodec(typ reflect.Type) columnCodec {
+	return newColumnCodec(typ, map[reflect.Type]*structCodec{})
+}
+
+// newColumnCodec builds the codec for the provided type. Struct codecs that are currently being
+// built are tracked so that recursive types can reference themselves.
+func newColumnCodec(typ reflect.Type, structs map[reflect.Type]*structCodec) columnCodec {
+	// Pointers are always handled by the pointer codec so that nil can be stored, the pointer
+	// codec will then check the type being pointed to.
+	if typ.Kind() != reflect.Ptr {
+		pointerType := reflect.PtrTo(typ)
+		if pointerType.Implements(columnEncoderType) && pointerType.Implements(columnDecoderType) {
+			return &columnEncoderCodec{}
+		}
+	}
+
+	if typ.Implements(valuerType) && reflect.PtrTo(typ).Implements(scannerType) {
+		return &valuerCodec{}
+	}
+
+	switch typ {
+	case timeType:
+		return &timeCodec{}
+	case bigIntType:
+		return &bigIntCodec{}
+	case bigFloatType:
+		return &bigFloatCodec{}
+	case bigRatType:
+		return &bigRatCodec{}
+	}
+
+	if typ.Kind() != reflect.Ptr {
+		pointerType := reflect.PtrTo(typ)
+		if pointerType.Implements(binaryMarshalerType) && pointerType.Implements(binaryUnmarshalerType) {
+			return &binaryMarshalerCodec{}
+		}
+	}
+
+	switch typ.Kind() {
+	case reflect.Ptr:
+		elem := newColumnCodec(typ.Elem(), structs)
+		if elem == nil {
+			return nil
+		}
+		return &pointerCodec{
+			elem: elem,
+		}
+	case reflect.String,
+		reflect.Bool,
+		reflect.Uint8, reflect.Uint16, reflect.Uint, reflect.Uint32, reflect.Uint64,
+		reflect.Int8, reflect.Int16, reflect.Int, reflect.Int32, reflect.Int64:
+		return &scalarCodec{}
+	case reflect.Float32, reflect.Float64:
+		return &floatCodec{}
+	case reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return &byteArrayCodec{}
+		}
+		elem := newColumnCodec(typ.Elem(), structs)
+		if elem == nil {
+			return nil
+		}
+		return &arrayCodec{
+			elem: elem,
+		}
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return &bytesCodec{}
+		}
+		elem := newColumnCodec(typ.Elem(), structs)
+		if elem == nil {
+			return nil
+		}
+		return &sliceCodec{
+			elem: elem,
+		}
+	case reflect.Map:
+		key, elem := newColumnCodec(typ.Key(), structs), newColumnCodec(typ.Elem(), structs)
+		if key == nil || elem == nil {
+			return nil
+		}
+		return &mapCodec{
+			key:  key,
+			elem: elem,
+		}
+	case reflect.Struct:
+		if codec, ok := structs[typ]; ok {
+			return codec
+		}
+
+		codec := &structCodec{
+			fields: make([]structCodecField, 0, typ.NumField()),
+		}
+		structs[typ] = codec
+
+		numFields := typ.NumField()
+		for i := 0; i < numFields; i++ {
+			field := typ.Field(i)
+			// Unexported fields cannot be read or set, so they are not stored.
+			if field.PkgPath != "" {
+				continue
+			}
+
+			fieldCodec := newColumnCodec(field.Type, structs)
+			if fieldCodec == nil {
+				return nil
+			}
+
+			codec.fields = append(codec.fields, structCodecField{
+				index: i,
+				codec: fieldCodec,
+			})
+		}
+
+		return codec
+	default:
+		return nil
+	}
+}
+
+// isNullValue returns true if the provided value should be treated as null.
+func isNullValue(value reflect.Value) bool {
+	if !value.IsValid() {
+		return true
+	}
+
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		if value.IsNil() {
+			return true
+		}
+	}
+
+	if value.Type().Implements(valuerType) {
+		driverValue, err := value.Interface().(driver.Valuer).Value()
+		return err == nil && driverValue == nil
+	}
+
+	return false
+}
+
+// addressOf returns a pointer to the provided value. If the value is not addressable then a pointer
+// to a copy of the value is returned instead.
+func addressOf(value reflect.Value) reflect.Value {
+	if value.CanAddr() {
+		return value.Addr()
+	}
+
+	pointer := reflect.New(value.Type())
+	pointer.Elem().Set(value)
+	return pointer
+}
+
+func (c *columnEncoderCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	encoded, err := addressOf(value).Interface().(ColumnEncoder).EncodeColumn()
+	if err != nil {
+		return err
+	}
+
+	buf.Append(encoded...)
+	return nil
+}
+
+func (c *columnEncoderCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	return target.Addr().Interface().(ColumnDecoder).DecodeColumn(reader.NextBytes())
+}
+
+func (b *binaryMarshalerCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	encoded, err := addressOf(value).Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	buf.Append(encoded...)
+	return nil
+}
+
+func (b *binaryMarshalerCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	return target.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(reader.NextBytes())
+}
+
+func (s *scalarCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	buf.AppendReflection(value)
+	return nil
+}
+
+func (s *scalarCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	// Even though we can probably set the field based on the base type we want to do this just
+	// in case the target is a custom type based on a normal kind.
+	target.Set(reflect.ValueOf(reader.NextReflection(target.Kind())).Convert(target.Type()))
+	return nil
+}
+
+func (p *pointerCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	if value.IsNil() {
+		buf.AppendByte(nullMarker)
+		return nil
+	}
+
+	buf.AppendByte(notNullMarker)
+	return p.elem.Append(buf, value.Elem())
+}
+
+func (p *pointerCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	if reader.NextByte() == nullMarker {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	elem := reflect.New(target.Type().Elem())
+	if err := p.elem.Read(reader, elem.Elem()); err != nil {
+		return err
+	}
+	target.Set(elem)
+
+	return nil
+}
+
+func (v *valuerCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	driverValue, err := value.Interface().(driver.Valuer).Value()
+	if err != nil {
+		return err
+	}
+
+	switch item := driverValue.(type) {
+	case nil:
+		buf.AppendByte(driverValueNull)
+	case int64:
+		buf.AppendByte(driverValueInt64)
+		buf.AppendInt64(item)
+	case float64:
+		buf.AppendByte(driverValueFloat64)
+		buf.AppendUint64(math.Float64bits(item))
+	case bool:
+		buf.AppendByte(driverValueBool)
+		buf.AppendBool(item)
+	case []byte:
+		buf.AppendByte(driverValueBytes)
+		buf.Append(item...)
+	case string:
+		buf.AppendByte(driverValueString)
+		buf.AppendString(item)
+	case time.Time:
+		buf.AppendByte(driverValueTime)
+		buf.AppendInt64(item.UnixNano())
+	default:
+		return fmt.Errorf("%T returned an unsupported driver value %T", value.Interface(), driverValue)
+	}
+
+	return nil
+}
+
+func (v *valuerCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	var driverValue interface{}
+	switch valueType := reader.NextByte(); valueType {
+	case driverValueNull:
+		driverValue = nil
+	case driverValueInt64:
+		driverValue = reader.NextInt64()
+	case driverValueFloat64:
+		driverValue = math.Float64frombits(reader.NextUint64())
+	case driverValueBool:
+		driverValue = reader.NextBool()
+	case driverValueBytes:
+		driverValue = reader.NextBytes()
+	case driverValueString:
+		driverValue = reader.NextString()
+	case driverValueTime:
+		driverValue = time.Unix(0, reader.NextInt64()).UTC()
+	default:
+		return fmt.Errorf("invalid driver value type [%d] for %s", valueType, target.Type())
+	}
+
+	return target.Addr().Interface().(sql.Scanner).Scan(driverValue)
+}
+
+func (f *floatCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	switch value.Kind() {
+	case reflect.Float32:
+		buf.AppendUint32(math.Float32bits(float32(value.Float())))
+	default:
+		buf.AppendUint64(math.Float64bits(value.Float()))
+	}
+
+	return nil
+}
+
+func (f *floatCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	switch target.Kind() {
+	case reflect.Float32:
+		target.SetFloat(float64(math.Float32frombits(reader.NextUint32())))
+	default:
+		target.SetFloat(math.Float64frombits(reader.NextUint64()))
+	}
+
+	return nil
+}
+
+func (t *timeCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	item := value.Interface().(time.Time)
+	buf.AppendInt64(item.Unix())
+	buf.AppendUint32(uint32(item.Nanosecond()))
+	return nil
+}
+
+func (t *timeCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	seconds, nanoseconds := reader.NextInt64(), reader.NextUint32()
+	target.Set(reflect.ValueOf(time.Unix(seconds, int64(nanoseconds)).UTC()))
+	return nil
+}
+
+func (b *bigIntCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	item := addressOf(value).Interface().(*big.Int)
+	buf.AppendByte(byte(item.Sign()))
+	buf.Append(item.Bytes()...)
+	return nil
+}
+
+func (b *bigIntCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	sign := reader.NextInt8()
+	item := target.Addr().Interface().(*big.Int)
+	item.SetBytes(reader.NextBytes())
+	if sign < 0 {
+		item.Neg(item)
+	}
+
+	return nil
+}
+
+func (b *bigFloatCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	encoded, err := addressOf(value).Interface().(*big.Float).GobEncode()
+	if err != nil {
+		return err
+	}
+
+	buf.Append(encoded...)
+	return nil
+}
+
+func (b *bigFloatCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	return target.Addr().Interface().(*big.Float).GobDecode(reader.NextBytes())
+}
+
+func (b *bigRatCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	encoded, err := addressOf(value).Interface().(*big.Rat).GobEncode()
+	if err != nil {
+		return err
+	}
+
+	buf.Append(encoded...)
+	return nil
+}
+
+func (b *bigRatCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	return target.Addr().Interface().(*big.Rat).GobDecode(reader.NextBytes())
+}
+
+func (b *byteArrayCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	size := value.Len()
+	for i := 0; i < size; i++ {
+		buf.AppendUint8(uint8(value.Index(i).Uint()))
+	}
+
+	return nil
+}
+
+func (b *byteArrayCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	size := target.Len()
+	for i := 0; i < size; i++ {
+		target.Index(i).SetUint(uint64(reader.NextUint8()))
+	}
+
+	return nil
+}
+
+func (b *bytesCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	buf.Append(value.Bytes()...)
+	return nil
+}
+
+func (b *bytesCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	item := reader.NextBytes()
+	if item == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	// The bytes returned by the reader are part of the entire datum value, so make a copy.
+	target.SetBytes(append(make([]byte, 0, len(item)), item...))
+	return nil
+}
+
+func (s *sliceCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	if value.IsNil() {
+		buf.AppendByte(nullMarker)
+		return nil
+	}
+
+	buf.AppendByte(notNullMarker)
+
+	size := value.Len()
+	buf.AppendUint32(uint32(size))
+	for i := 0; i < size; i++ {
+		if err := s.elem.Append(buf, value.Index(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *sliceCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	if reader.NextByte() == nullMarker {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	size := int(reader.NextUint32())
+	items := reflect.MakeSlice(target.Type(), size, size)
+	for i := 0; i < size; i++ {
+		if err := s.elem.Read(reader, items.Index(i)); err != nil {
+			return err
+		}
+	}
+	target.Set(items)
+
+	return nil
+}
+
+func (a *arrayCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	size := value.Len()
+	for i := 0; i < size; i++ {
+		if err := a.elem.Append(buf, value.Index(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *arrayCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	size := target.Len()
+	for i := 0; i < size; i++ {
+		if err := a.elem.Read(reader, target.Index(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *mapCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	if value.IsNil() {
+		buf.AppendByte(nullMarker)
+		return nil
+	}
+
+	buf.AppendByte(notNullMarker)
+
+	type entry struct {
+		key   []byte
+		value reflect.Value
+	}
+
+	entries := make([]entry, 0, value.Len())
+	for _, key := range value.MapKeys() {
+		keyBuf := buffers.NewBytesBuffer()
+		if err := m.key.Append(keyBuf, key); err != nil {
+			return err
+		}
+
+		entries = append(entries, entry{
+			key:   keyBuf.Bytes(),
+			value: value.MapIndex(key),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	buf.AppendUint32(uint32(len(entries)))
+	for _, item := range entries {
+		buf.AppendRaw(item.key)
+		if err := m.elem.Append(buf, item.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *mapCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	if reader.NextByte() == nullMarker {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	size := int(reader.NextUint32())
+	items := reflect.MakeMapWithSize(target.Type(), size)
+	for i := 0; i < size; i++ {
+		key := reflect.New(target.Type().Key()).Elem()
+		if err := m.key.Read(reader, key); err != nil {
+			return err
+		}
+
+		elem := reflect.New(target.Type().Elem()).Elem()
+		if err := m.elem.Read(reader, elem); err != nil {
+			return err
+		}
+
+		items.SetMapIndex(key, elem)
+	}
+	target.Set(items)
+
+	return nil
+}
+
+func (s *structCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	for _, field := range s.fields {
+		if err := field.codec.Append(buf, value.Field(field.index)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *structCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	for _, field := range s.fields {
+		if err := field.codec.Read(reader, target.Field(field.index)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (j *jsonCodec) Append(buf buffers.BytesBuffer, value reflect.Value) error {
+	encoded, err := json.Marshal(value.Interface())
+	if err != nil {
+		return err
+	}
+
+	buf.Append(encoded...)
+	return nil
+}
+
+func (j *jsonCodec) Read(reader buffers.BytesReader, target reflect.Value) error {
+	return json.Unmarshal(reader.NextBytes(), target.Addr().Interface())
+}
@@ -0,0 +1,673 @@
+package mellivora
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"github.com/elliotcourant/buffers"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Variable length values in keys are escaped so that they can be terminated without a length
+// prefix. A length prefix would make shorter values always sort before longer values. Every 0x00
+// byte in the value is written as 0x00 0xFF and the value is terminated with 0x00 0x01.
+const (
+	keyEscapeByte     = byte(0x00)
+	keyEscapedByte    = byte(0xFF)
+	keyTerminatorByte = byte(0x01)
+)
+
+// Items in slices and maps are each preceded by keyContinueByte and the end of the collection is
+// marked with keyEndByte, this way a shorter collection sorts before a longer one that starts with
+// the same items.
+const (
+	keyEndByte      = byte(0x00)
+	keyContinueByte = byte(0x01)
+)
+
+// isOrderedCodec returns true if the keys written by the codec sort the same way that the values
+// themselves do. Values with keys that do not sort can only be compared for equality.
+func isOrderedCodec(codec columnCodec) bool {
+	switch item := codec.(type) {
+	case *pointerCodec:
+		return isOrderedCodec(item.elem)
+	case *sliceCodec:
+		return isOrderedCodec(item.elem)
+	case *arrayCodec:
+		return isOrderedCodec(item.elem)
+	case *structCodec:
+		for _, field := range item.fields {
+			if !isOrderedCodec(field.codec) {
+				return false
+			}
+		}
+		return true
+	case *mapCodec, *bigFloatCodec, *bigRatCodec, *jsonCodec, *columnEncoderCodec, *binaryMarshalerCodec:
+		return false
+	default:
+		return true
+	}
+}
+
+func appendKeyBytes(buf buffers.BytesBuffer, item []byte) {
+	for _, b := range item {
+		buf.AppendByte(b)
+		if b == keyEscapeByte {
+			buf.AppendByte(keyEscapedByte)
+		}
+	}
+	buf.AppendByte(keyEscapeByte)
+	buf.AppendByte(keyTerminatorByte)
+}
+
+func readKeyBytes(reader buffers.BytesReader) ([]byte, error) {
+	item := make([]byte, 0)
+	for {
+		b := reader.NextByte()
+		if b != keyEscapeByte {
+			item = append(item, b)
+			continue
+		}
+
+		switch escaped := reader.NextByte(); escaped {
+		case keyEscapedByte:
+			item = append(item, keyEscapeByte)
+		case keyTerminatorByte:
+			return item, nil
+		default:
+			return nil, fmt.Errorf("invalid escape sequence [%X %X] in key", b, escaped)
+		}
+	}
+}
+
+// appendKeyUint writes the unsigned integer using the provided number of bits, big endian so that
+// the bytes sort the same way that the number does.
+func appendKeyUint(buf buffers.BytesBuffer, item uint64, bits int) {
+	switch bits {
+	case 8:
+		buf.AppendUint8(uint8(item))
+	case 16:
+		buf.AppendUint16(uint16(item))
+	case 32:
+		buf.AppendUint32(uint32(item))
+	default:
+		buf.AppendUint64(item)
+	}
+}
+
+func readKeyUint(reader buffers.BytesReader, bits int) uint64 {
+	switch bits {
+	case 8:
+		return uint64(reader.NextUint8())
+	case 16:
+		return uint64(reader.NextUint16())
+	case 32:
+		return uint64(reader.NextUint32())
+	default:
+		return reader.NextUint64()
+	}
+}
+
+// appendKeyInt writes the signed integer with its sign bit flipped. This makes negative numbers
+// sort before positive numbers.
+func appendKeyInt(buf buffers.BytesBuffer, item int64, bits int) {
+	appendKeyUint(buf, uint64(item)^(1<<uint(bits-1)), bits)
+}
+
+func readKeyInt(reader buffers.BytesReader, bits int) int64 {
+	item := readKeyUint(reader, bits) ^ (1 << uint(bits-1))
+	switch bits {
+	case 8:
+		return int64(int8(item))
+	case 16:
+		return int64(int16(item))
+	case 32:
+		return int64(int32(item))
+	default:
+		return int64(item)
+	}
+}
+
+// appendKeyFloat writes the float's bits with the sign bit flipped for positive numbers and every
+// bit flipped for negative numbers, which makes the bits sort the same way the float does.
+func appendKeyFloat(buf buffers.BytesBuffer, item float64, bits int) {
+	switch bits {
+	case 32:
+		encoded := math.Float32bits(float32(item))
+		if encoded&(1<<31) != 0 {
+			encoded = ^encoded
+		} else {
+			encoded |= 1 << 31
+		}
+		buf.AppendUint32(encoded)
+	default:
+		encoded := math.Float64bits(item)
+		if encoded&(1<<63) != 0 {
+			encoded = ^encoded
+		} else {
+			encoded |= 1 << 63
+		}
+		buf.AppendUint64(encoded)
+	}
+}
+
+func readKeyFloat(reader buffers.BytesReader, bits int) float64 {
+	switch bits {
+	case 32:
+		encoded := reader.NextUint32()
+		if encoded&(1<<31) != 0 {
+			encoded &^= 1 << 31
+		} else {
+			encoded = ^encoded
+		}
+		return float64(math.Float32frombits(encoded))
+	default:
+		encoded := reader.NextUint64()
+		if encoded&(1<<63) != 0 {
+			encoded &^= 1 << 63
+		} else {
+			encoded = ^encoded
+		}
+		return math.Float64frombits(encoded)
+	}
+}
+
+func (c *columnEncoderCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	encoded, err := addressOf(value).Interface().(ColumnEncoder).EncodeColumn()
+	if err != nil {
+		return err
+	}
+
+	appendKeyBytes(buf, encoded)
+	return nil
+}
+
+func (c *columnEncoderCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	encoded, err := readKeyBytes(reader)
+	if err != nil {
+		return err
+	}
+
+	return target.Addr().Interface().(ColumnDecoder).DecodeColumn(encoded)
+}
+
+func (b *binaryMarshalerCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	encoded, err := addressOf(value).Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	appendKeyBytes(buf, encoded)
+	return nil
+}
+
+func (b *binaryMarshalerCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	encoded, err := readKeyBytes(reader)
+	if err != nil {
+		return err
+	}
+
+	return target.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(encoded)
+}
+
+func (s *scalarCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	switch value.Kind() {
+	case reflect.String:
+		appendKeyBytes(buf, []byte(value.String()))
+	case reflect.Bool:
+		buf.AppendBool(value.Bool())
+	case reflect.Uint8, reflect.Uint16, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		appendKeyUint(buf, value.Uint(), value.Type().Bits())
+	case reflect.Int8, reflect.Int16, reflect.Int, reflect.Int32, reflect.Int64:
+		appendKeyInt(buf, value.Int(), value.Type().Bits())
+	default:
+		return fmt.Errorf("cannot use %s in a key", value.Type())
+	}
+
+	return nil
+}
+
+func (s *scalarCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	switch target.Kind() {
+	case reflect.String:
+		item, err := readKeyBytes(reader)
+		if err != nil {
+			return err
+		}
+		target.SetString(string(item))
+	case reflect.Bool:
+		target.SetBool(reader.NextBool())
+	case reflect.Uint8, reflect.Uint16, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		target.SetUint(readKeyUint(reader, target.Type().Bits()))
+	case reflect.Int8, reflect.Int16, reflect.Int, reflect.Int32, reflect.Int64:
+		target.SetInt(readKeyInt(reader, target.Type().Bits()))
+	default:
+		return fmt.Errorf("cannot read %s from a key", target.Type())
+	}
+
+	return nil
+}
+
+func (p *pointerCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	// The null marker is lower than the not null marker, so nulls will sort first.
+	if value.IsNil() {
+		buf.AppendByte(nullMarker)
+		return nil
+	}
+
+	buf.AppendByte(notNullMarker)
+	return p.elem.AppendKey(buf, value.Elem())
+}
+
+func (p *pointerCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	if reader.NextByte() == nullMarker {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	elem := reflect.New(target.Type().Elem())
+	if err := p.elem.ReadKey(reader, elem.Elem()); err != nil {
+		return err
+	}
+	target.Set(elem)
+
+	return nil
+}
+
+func (v *valuerCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	driverValue, err := value.Interface().(driver.Valuer).Value()
+	if err != nil {
+		return err
+	}
+
+	switch item := driverValue.(type) {
+	case nil:
+		buf.AppendByte(driverValueNull)
+	case int64:
+		buf.AppendByte(driverValueInt64)
+		appendKeyInt(buf, item, 64)
+	case float64:
+		buf.AppendByte(driverValueFloat64)
+		appendKeyFloat(buf, item, 64)
+	case bool:
+		buf.AppendByte(driverValueBool)
+		buf.AppendBool(item)
+	case []byte:
+		buf.AppendByte(driverValueBytes)
+		appendKeyBytes(buf, item)
+	case string:
+		buf.AppendByte(driverValueString)
+		appendKeyBytes(buf, []byte(item))
+	case time.Time:
+		buf.AppendByte(driverValueTime)
+		appendKeyInt(buf, item.UnixNano(), 64)
+	default:
+		return fmt.Errorf("%T returned an unsupported driver value %T", value.Interface(), driverValue)
+	}
+
+	return nil
+}
+
+func (v *valuerCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	var driverValue interface{}
+	switch valueType := reader.NextByte(); valueType {
+	case driverValueNull:
+		driverValue = nil
+	case driverValueInt64:
+		driverValue = readKeyInt(reader, 64)
+	case driverValueFloat64:
+		driverValue = readKeyFloat(reader, 64)
+	case driverValueBool:
+		driverValue = reader.NextBool()
+	case driverValueBytes:
+		item, err := readKeyBytes(reader)
+		if err != nil {
+			return err
+		}
+		driverValue = item
+	case driverValueString:
+		item, err := readKeyBytes(reader)
+		if err != nil {
+			return err
+		}
+		driverValue = string(item)
+	case driverValueTime:
+		driverValue = time.Unix(0, readKeyInt(reader, 64)).UTC()
+	default:
+		return fmt.Errorf("invalid driver value type [%d] for %s", valueType, target.Type())
+	}
+
+	return target.Addr().Interface().(sql.Scanner).Scan(driverValue)
+}
+
+func (f *floatCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	appendKeyFloat(buf, value.Float(), value.Type().Bits())
+	return nil
+}
+
+func (f *floatCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	target.SetFloat(readKeyFloat(reader, target.Type().Bits()))
+	return nil
+}
+
+func (t *timeCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	item := value.Interface().(time.Time)
+	appendKeyInt(buf, item.Unix(), 64)
+	buf.AppendUint32(uint32(item.Nanosecond()))
+	return nil
+}
+
+func (t *timeCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	seconds, nanoseconds := readKeyInt(reader, 64), reader.NextUint32()
+	target.Set(reflect.ValueOf(time.Unix(seconds, int64(nanoseconds)).UTC()))
+	return nil
+}
+
+// Big integers in keys are written as a sign byte followed by the number of bytes in the absolute
+// value and then the bytes themselves. For negative numbers the length and the bytes are inverted
+// so that larger absolute values sort first.
+const (
+	bigIntKeyNegative = byte(iota)
+	bigIntKeyZero
+	bigIntKeyPositive
+)
+
+func (b *bigIntCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	item := addressOf(value).Interface().(*big.Int)
+	magnitude := item.Bytes()
+	switch item.Sign() {
+	case 0:
+		buf.AppendByte(bigIntKeyZero)
+	case 1:
+		buf.AppendByte(bigIntKeyPositive)
+		buf.AppendUint32(uint32(len(magnitude)))
+		buf.AppendRaw(magnitude)
+	default:
+		buf.AppendByte(bigIntKeyNegative)
+		buf.AppendUint32(^uint32(len(magnitude)))
+		for _, b := range magnitude {
+			buf.AppendByte(^b)
+		}
+	}
+
+	return nil
+}
+
+func (b *bigIntCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	item := target.Addr().Interface().(*big.Int)
+	switch sign := reader.NextByte(); sign {
+	case bigIntKeyZero:
+		item.SetInt64(0)
+	case bigIntKeyPositive:
+		magnitude := make([]byte, reader.NextUint32())
+		for i := range magnitude {
+			magnitude[i] = reader.NextByte()
+		}
+		item.SetBytes(magnitude)
+	case bigIntKeyNegative:
+		magnitude := make([]byte, ^reader.NextUint32())
+		for i := range magnitude {
+			magnitude[i] = ^reader.NextByte()
+		}
+		item.SetBytes(magnitude)
+		item.Neg(item)
+	default:
+		return fmt.Errorf("invalid big.Int sign [%d] in key", sign)
+	}
+
+	return nil
+}
+
+func (b *bigFloatCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	// The gob encoding does not sort like the number does, so big.Float keys can only be used
+	// for equality.
+	encoded, err := addressOf(value).Interface().(*big.Float).GobEncode()
+	if err != nil {
+		return err
+	}
+
+	appendKeyBytes(buf, encoded)
+	return nil
+}
+
+func (b *bigFloatCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	encoded, err := readKeyBytes(reader)
+	if err != nil {
+		return err
+	}
+
+	return target.Addr().Interface().(*big.Float).GobDecode(encoded)
+}
+
+func (b *bigRatCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	// The gob encoding does not sort like the number does, so big.Rat keys can only be used for
+	// equality.
+	encoded, err := addressOf(value).Interface().(*big.Rat).GobEncode()
+	if err != nil {
+		return err
+	}
+
+	appendKeyBytes(buf, encoded)
+	return nil
+}
+
+func (b *bigRatCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	encoded, err := readKeyBytes(reader)
+	if err != nil {
+		return err
+	}
+
+	return target.Addr().Interface().(*big.Rat).GobDecode(encoded)
+}
+
+func (b *byteArrayCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	// Byte arrays are a fixed size, so they already sort correctly and are never a prefix of one
+	// another.
+	return b.Append(buf, value)
+}
+
+func (b *byteArrayCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	return b.Read(reader, target)
+}
+
+func (b *bytesCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	if value.IsNil() {
+		buf.AppendByte(nullMarker)
+		return nil
+	}
+
+	buf.AppendByte(notNullMarker)
+	appendKeyBytes(buf, value.Bytes())
+	return nil
+}
+
+func (b *bytesCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	if reader.NextByte() == nullMarker {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	item, err := readKeyBytes(reader)
+	if err != nil {
+		return err
+	}
+	target.SetBytes(item)
+
+	return nil
+}
+
+func (s *sliceCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	if value.IsNil() {
+		buf.AppendByte(nullMarker)
+		return nil
+	}
+
+	buf.AppendByte(notNullMarker)
+
+	size := value.Len()
+	for i := 0; i < size; i++ {
+		buf.AppendByte(keyContinueByte)
+		if err := s.elem.AppendKey(buf, value.Index(i)); err != nil {
+			return err
+		}
+	}
+	buf.AppendByte(keyEndByte)
+
+	return nil
+}
+
+func (s *sliceCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	if reader.NextByte() == nullMarker {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	items := reflect.MakeSlice(target.Type(), 0, 0)
+	for reader.NextByte() == keyContinueByte {
+		item := reflect.New(target.Type().Elem()).Elem()
+		if err := s.elem.ReadKey(reader, item); err != nil {
+			return err
+		}
+		items = reflect.Append(items, item)
+	}
+	target.Set(items)
+
+	return nil
+}
+
+func (a *arrayCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	size := value.Len()
+	for i := 0; i < size; i++ {
+		if err := a.elem.AppendKey(buf, value.Index(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *arrayCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	size := target.Len()
+	for i := 0; i < size; i++ {
+		if err := a.elem.ReadKey(reader, target.Index(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *mapCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	// Maps do not have an order, but the entries are sorted by their key so that the same map
+	// will always produce the same key.
+	if value.IsNil() {
+		buf.AppendByte(nullMarker)
+		return nil
+	}
+
+	buf.AppendByte(notNullMarker)
+
+	type entry struct {
+		key   []byte
+		value reflect.Value
+	}
+
+	entries := make([]entry, 0, value.Len())
+	for _, key := range value.MapKeys() {
+		keyBuf := buffers.NewBytesBuffer()
+		if err := m.key.AppendKey(keyBuf, key); err != nil {
+			return err
+		}
+
+		entries = append(entries, entry{
+			key:   keyBuf.Bytes(),
+			value: value.MapIndex(key),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	for _, item := range entries {
+		buf.AppendByte(keyContinueByte)
+		buf.AppendRaw(item.key)
+		if err := m.elem.AppendKey(buf, item.value); err != nil {
+			return err
+		}
+	}
+	buf.AppendByte(keyEndByte)
+
+	return nil
+}
+
+func (m *mapCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	if reader.NextByte() == nullMarker {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	items := reflect.MakeMap(target.Type())
+	for reader.NextByte() == keyContinueByte {
+		key := reflect.New(target.Type().Key()).Elem()
+		if err := m.key.ReadKey(reader, key); err != nil {
+			return err
+		}
+
+		elem := reflect.New(target.Type().Elem()).Elem()
+		if err := m.elem.ReadKey(reader, elem); err != nil {
+			return err
+		}
+
+		items.SetMapIndex(key, elem)
+	}
+	target.Set(items)
+
+	return nil
+}
+
+func (s *structCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	for _, field := range s.fields {
+		if err := field.codec.AppendKey(buf, value.Field(field.index)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *structCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	for _, field := range s.fields {
+		if err := field.codec.ReadKey(reader, target.Field(field.index)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (j *jsonCodec) AppendKey(buf buffers.BytesBuffer, value reflect.Value) error {
+	encoded, err := json.Marshal(value.Interface())
+	if err != nil {
+		return err
+	}
+
+	appendKeyBytes(buf, encoded)
+	return nil
+}
+
+func (j *jsonCodec) ReadKey(reader buffers.BytesReader, target reflect.Value) error {
+	encoded, err := readKeyBytes(reader)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(encoded, target.Addr().Interface())
+}
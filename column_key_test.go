@@ -0,0 +1,145 @@
+package mellivora
+
+import (
+	"bytes"
+	"database/sql"
+	"github.com/elliotcourant/buffers"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestColumnCodec_AppendKey(t *testing.T) {
+	one, two := "one", "two"
+	negative, positive, large := big.NewInt(-500), big.NewInt(500), new(big.Int)
+	large.SetString("123456789012345678901234567890", 10)
+
+	cases := []struct {
+		name   string
+		values []interface{}
+	}{
+		{
+			name:   "int64",
+			values: []interface{}{int64(math.MinInt64), int64(-1000), int64(-1), int64(0), int64(1), int64(math.MaxInt64)},
+		},
+		{
+			name:   "int",
+			values: []interface{}{-70000, -1, 0, 1, 5432, 70000},
+		},
+		{
+			name:   "int8",
+			values: []interface{}{int8(-128), int8(-1), int8(0), int8(127)},
+		},
+		{
+			name:   "uint32",
+			values: []interface{}{uint32(0), uint32(255), uint32(256), uint32(math.MaxUint32)},
+		},
+		{
+			name:   "uint",
+			values: []interface{}{uint(0), uint(1), uint(math.MaxUint32 + 1)},
+		},
+		{
+			name:   "string",
+			values: []interface{}{"", "\x00", "\x00\x00", "a", "a\x00", "a\x00b", "ab", "b"},
+		},
+		{
+			name:   "bool",
+			values: []interface{}{false, true},
+		},
+		{
+			name:   "float64",
+			values: []interface{}{math.Inf(-1), -1000.5, -1.0, -0.25, 0.0, 0.25, 1.0, 1000.5, math.Inf(1)},
+		},
+		{
+			name:   "float32",
+			values: []interface{}{float32(-10.5), float32(-0.5), float32(0), float32(0.5), float32(10.5)},
+		},
+		{
+			name: "time",
+			values: []interface{}{
+				time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(1970, 1, 1, 0, 0, 0, 1, time.UTC),
+				time.Date(2019, 12, 1, 4, 21, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:   "big.Int",
+			values: []interface{}{*new(big.Int).Neg(large), *negative, *big.NewInt(-1), *big.NewInt(0), *big.NewInt(1), *positive, *large},
+		},
+		{
+			name:   "byte array",
+			values: []interface{}{[4]byte{0, 0, 0, 0}, [4]byte{0, 0, 0, 1}, [4]byte{1, 0, 0, 0}},
+		},
+		{
+			name:   "bytes",
+			values: []interface{}{[]byte(nil), []byte{}, []byte{0}, []byte{0, 1}, []byte{1}},
+		},
+		{
+			name:   "pointer",
+			values: []interface{}{(*string)(nil), &one, &two},
+		},
+		{
+			name: "sql.NullInt64",
+			values: []interface{}{
+				sql.NullInt64{},
+				sql.NullInt64{Int64: -5, Valid: true},
+				sql.NullInt64{Int64: 5, Valid: true},
+			},
+		},
+		{
+			name:   "slice",
+			values: []interface{}{[]string(nil), []string{}, []string{"a"}, []string{"a", "b"}, []string{"b"}},
+		},
+	}
+
+	for _, item := range cases {
+		t.Run(item.name, func(t *testing.T) {
+			codec := getColumnCodec(reflect.TypeOf(item.values[0]))
+			assert.NotNil(t, codec)
+
+			keys := make([][]byte, len(item.values))
+			for i, value := range item.values {
+				buf := buffers.NewBytesBuffer()
+				assert.NoError(t, codec.AppendKey(buf, reflect.ValueOf(value)))
+				keys[i] = buf.Bytes()
+
+				read := reflect.New(reflect.TypeOf(value)).Elem()
+				assert.NoError(t, codec.ReadKey(buffers.NewBytesReader(keys[i]), read))
+				assert.Equal(t, value, read.Interface())
+			}
+
+			for i := 1; i < len(keys); i++ {
+				assert.True(t, bytes.Compare(keys[i-1], keys[i]) < 0,
+					"expected %v to sort before %v", item.values[i-1], item.values[i])
+			}
+		})
+	}
+
+	t.Run("tuple", func(t *testing.T) {
+		// When multiple values make up a key the first value should take priority, a longer
+		// string in the first position should not change the order.
+		codec := getColumnCodec(reflect.TypeOf(""))
+		tuples := [][]string{
+			{"a", "z"},
+			{"ab", "a"},
+			{"b", "a"},
+		}
+
+		keys := make([][]byte, len(tuples))
+		for i, tuple := range tuples {
+			buf := buffers.NewBytesBuffer()
+			for _, value := range tuple {
+				assert.NoError(t, codec.AppendKey(buf, reflect.ValueOf(value)))
+			}
+			keys[i] = buf.Bytes()
+		}
+
+		for i := 1; i < len(keys); i++ {
+			assert.True(t, bytes.Compare(keys[i-1], keys[i]) < 0)
+		}
+	})
+}
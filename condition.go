@@ -0,0 +1,576 @@
+package mellivora
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/elliotcourant/buffers"
+	"math"
+	"reflect"
+	"strings"
+)
+
+type operator int
+
+const (
+	operatorEqual operator = iota
+	operatorIn
+	operatorIsNull
+	operatorIsNotNull
+	operatorGreaterThan
+	operatorGreaterThanOrEqual
+	operatorLessThan
+	operatorLessThanOrEqual
+	operatorBetween
+)
+
+// Condition is a comparison that can be used as a value in an Ex to filter a field by something
+// other than equality.
+type Condition struct {
+	operator operator
+	values   []interface{}
+}
+
+// IsNull matches records where the field is a nil pointer or a sql.Null* type that is not valid.
+func IsNull() Condition {
+	return Condition{
+		operator: operatorIsNull,
+	}
+}
+
+// IsNotNull matches records where the field has a value.
+func IsNotNull() Condition {
+	return Condition{
+		operator: operatorIsNotNull,
+	}
+}
+
+// Gt matches records where the field is greater than the provided value.
+func Gt(value interface{}) Condition {
+	return Condition{
+		operator: operatorGreaterThan,
+		values:   []interface{}{value},
+	}
+}
+
+// Gte matches records where the field is greater than or equal to the provided value.
+func Gte(value interface{}) Condition {
+	return Condition{
+		operator: operatorGreaterThanOrEqual,
+		values:   []interface{}{value},
+	}
+}
+
+// Lt matches records where the field is less than the provided value.
+func Lt(value interface{}) Condition {
+	return Condition{
+		operator: operatorLessThan,
+		values:   []interface{}{value},
+	}
+}
+
+// Lte matches records where the field is less than or equal to the provided value.
+func Lte(value interface{}) Condition {
+	return Condition{
+		operator: operatorLessThanOrEqual,
+		values:   []interface{}{value},
+	}
+}
+
+// Between matches records where the field is greater than or equal to low and less than or equal
+// to high.
+func Between(low, high interface{}) Condition {
+	return Condition{
+		operator: operatorBetween,
+		values:   []interface{}{low, high},
+	}
+}
+
+// newCondition converts a value provided in an Ex into a condition. Nil values are treated as
+// IsNull, slices and arrays are treated as an IN and everything else is an equality check.
+func newCondition(value interface{}) Condition {
+	if value == nil {
+		return IsNull()
+	}
+
+	if condition, ok := value.(Condition); ok {
+		return condition
+	}
+
+	switch reflect.TypeOf(value).Kind() {
+	case reflect.Slice, reflect.Array:
+		valueReflection := reflect.ValueOf(value)
+		size := valueReflection.Len()
+		values := make([]interface{}, size)
+		for i := 0; i < size; i++ {
+			values[i] = valueReflection.Index(i).Interface()
+		}
+
+		return Condition{
+			operator: operatorIn,
+			values:   values,
+		}
+	default:
+		return Condition{
+			operator: operatorEqual,
+			values:   []interface{}{value},
+		}
+	}
+}
+
+// isRange returns true if the condition compares the order of values rather than equality.
+func (c Condition) isRange() bool {
+	switch c.operator {
+	case operatorGreaterThan,
+		operatorGreaterThanOrEqual,
+		operatorLessThan,
+		operatorLessThanOrEqual,
+		operatorBetween:
+		return true
+	default:
+		return false
+	}
+}
+
+// describe returns a human readable description of the condition being applied to the field.
+func (c Condition) describe(fieldName string) string {
+	switch c.operator {
+	case operatorIsNull:
+		return fmt.Sprintf("%s IS NULL", fieldName)
+	case operatorIsNotNull:
+		return fmt.Sprintf("%s IS NOT NULL", fieldName)
+	case operatorIn:
+		values := make([]string, len(c.values))
+		for i, value := range c.values {
+			values[i] = fmt.Sprintf("%v", value)
+		}
+		return fmt.Sprintf("%s IN (%s)", fieldName, strings.Join(values, ", "))
+	case operatorGreaterThan:
+		return fmt.Sprintf("%s > %v", fieldName, c.values[0])
+	case operatorGreaterThanOrEqual:
+		return fmt.Sprintf("%s >= %v", fieldName, c.values[0])
+	case operatorLessThan:
+		return fmt.Sprintf("%s < %v", fieldName, c.values[0])
+	case operatorLessThanOrEqual:
+		return fmt.Sprintf("%s <= %v", fieldName, c.values[0])
+	case operatorBetween:
+		return fmt.Sprintf("%s BETWEEN %v AND %v", fieldName, c.values[0], c.values[1])
+	default:
+		return fmt.Sprintf("%s = %v", fieldName, c.values[0])
+	}
+}
+
+// predicate returns a function that can be used to test a field's value against the condition.
+func (c Condition) predicate() func(value reflect.Value) bool {
+	switch c.operator {
+	case operatorIsNull:
+		return isNullValue
+	case operatorIsNotNull:
+		return func(value reflect.Value) bool {
+			return !isNullValue(value)
+		}
+	case operatorIn:
+		comparer := &keyComparer{
+			values:    c.values,
+			unordered: true,
+		}
+
+		inMap := map[string]interface{}{}
+		for _, item := range c.values {
+			inMap[fmt.Sprint(item)] = nil
+		}
+
+		return func(value reflect.Value) bool {
+			if isNullValue(value) {
+				return false
+			}
+
+			if found, ok := comparer.contains(value); ok {
+				return found
+			}
+
+			// Values that cannot be encoded as a key are compared by how they are printed.
+			_, ok := inMap[fmt.Sprint(comparableValue(value))]
+			return ok
+		}
+	case operatorGreaterThan,
+		operatorGreaterThanOrEqual,
+		operatorLessThan,
+		operatorLessThanOrEqual,
+		operatorBetween:
+		comparer := &keyComparer{
+			values: c.values,
+		}
+
+		return func(value reflect.Value) bool {
+			if isNullValue(value) {
+				return false
+			}
+
+			// The first comparison is always against the first value of the condition, which is
+			// the low value of a between.
+			comparison, ok := comparer.compare(value, 0)
+			if !ok {
+				return false
+			}
+
+			switch c.operator {
+			case operatorGreaterThan:
+				return comparison > 0
+			case operatorGreaterThanOrEqual:
+				return comparison >= 0
+			case operatorLessThan:
+				return comparison < 0
+			case operatorLessThanOrEqual:
+				return comparison <= 0
+			default:
+				if comparison < 0 {
+					return false
+				}
+
+				comparison, ok = comparer.compare(value, 1)
+				return ok && comparison <= 0
+			}
+		}
+	default:
+		comparer := &keyComparer{
+			values:    c.values,
+			unordered: true,
+		}
+
+		filterValue := fmt.Sprint(c.values[0])
+		return func(value reflect.Value) bool {
+			if isNullValue(value) {
+				return false
+			}
+
+			// Values are compared by their key encoding like the range conditions, so values that
+			// are equal but print differently, like times with a monotonic clock, still match.
+			if comparison, ok := comparer.compare(value, 0); ok {
+				return comparison == 0
+			}
+
+			// Values that cannot be encoded as a key are compared by how they are printed.
+			return filterValue == fmt.Sprint(comparableValue(value))
+		}
+	}
+}
+
+// keyComparer compares values by their key encoding, this way values are compared using the same
+// order that they are stored in. The values of the condition are converted to the type of the
+// value being compared and encoded once per type.
+type keyComparer struct {
+	values []interface{}
+
+	// unordered comparers are only used to check if values are equal, so they can use key
+	// encodings that do not sort like their values.
+	unordered bool
+
+	typ   reflect.Type
+	codec columnCodec
+	keys  []filterKey
+}
+
+// filterKey is the key encoding of one of a condition's values. Values that cannot be represented
+// by the type being compared do not have a key of their own, numbers outside of the range of the
+// type are greater or less than every value and fractions are compared against the whole number
+// below them. Neither of them are ever equal to a value.
+type filterKey struct {
+	key      []byte
+	outside  int
+	fraction bool
+}
+
+// compare returns -1 if the value is less than the condition's value at the provided index, 0 if
+// they are equal and 1 if the value is greater. If the values cannot be compared then false is
+// returned.
+func (k *keyComparer) compare(value reflect.Value, index int) (int, bool) {
+	key, ok := k.encode(value)
+	if !ok {
+		return 0, false
+	}
+
+	item := k.keys[index]
+	switch {
+	case item.outside != 0:
+		return -item.outside, true
+	case item.fraction:
+		if bytes.Compare(key, item.key) <= 0 {
+			return -1, true
+		}
+
+		return 1, true
+	default:
+		return bytes.Compare(key, item.key), true
+	}
+}
+
+// contains returns true if the value is equal to any of the condition's values. If the values
+// cannot be compared then false is returned for ok.
+func (k *keyComparer) contains(value reflect.Value) (found bool, ok bool) {
+	key, ok := k.encode(value)
+	if !ok {
+		return false, false
+	}
+
+	for _, item := range k.keys {
+		if item.outside == 0 && !item.fraction && bytes.Equal(key, item.key) {
+			return true, true
+		}
+	}
+
+	return false, true
+}
+
+// encode returns the key encoding of the value, encoding the condition's values first if this is
+// the first value of its type.
+func (k *keyComparer) encode(value reflect.Value) ([]byte, bool) {
+	if value.Type() != k.typ {
+		k.typ, k.codec, k.keys = value.Type(), nil, nil
+
+		codec := getColumnCodec(value.Type())
+		if codec == nil || (!k.unordered && !isOrderedCodec(codec)) {
+			return nil, false
+		}
+
+		keys := make([]filterKey, len(k.values))
+		for i, item := range k.values {
+			key, err := encodeFilterKey(codec, nil, item, value.Type())
+			if err != nil {
+				return nil, false
+			}
+			keys[i] = key
+		}
+
+		k.codec, k.keys = codec, keys
+	}
+
+	if k.codec == nil {
+		return nil, false
+	}
+
+	buf := buffers.NewBytesBuffer()
+	if err := k.codec.AppendKey(buf, value); err != nil {
+		return nil, false
+	}
+
+	return buf.Bytes(), true
+}
+
+// encodeFilterKey returns the key encoding of a condition's value after the prefix. The value is
+// converted to the provided type first, values that cannot be represented by the type are returned
+// without a key.
+func encodeFilterKey(codec columnCodec, prefix []byte, value interface{}, typ reflect.Type) (filterKey, error) {
+	key := filterKey{}
+	converted, err := convertFilterValue(value, typ)
+
+	var lossy *lossyConversionError
+	if errors.As(err, &lossy) && lossy.outside == 0 {
+		key.fraction = true
+		floor := math.Floor(reflect.ValueOf(lossy.value).Float())
+		converted, err = convertFilterValue(floor, typ)
+	}
+
+	switch {
+	case errors.As(err, &lossy):
+		key.outside = lossy.outside
+		return key, nil
+	case err != nil:
+		return key, err
+	}
+
+	buf := buffers.NewBytesBuffer()
+	buf.AppendRaw(prefix)
+	if err := codec.AppendKey(buf, converted); err != nil {
+		return key, err
+	}
+	key.key = buf.Bytes()
+
+	return key, nil
+}
+
+// convertFilterValue converts a value provided in a filter to the type of the field that it is
+// being compared against.
+func convertFilterValue(value interface{}, typ reflect.Type) (reflect.Value, error) {
+	if value == nil {
+		return reflect.Value{}, fmt.Errorf("cannot convert nil to %s", typ)
+	}
+
+	reflection := reflect.ValueOf(value)
+	if reflection.Type() == typ {
+		return reflection, nil
+	}
+
+	if typ.Kind() == reflect.Ptr {
+		elem, err := convertFilterValue(value, typ.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		pointer := reflect.New(typ.Elem())
+		pointer.Elem().Set(elem)
+		return pointer, nil
+	}
+
+	if reflect.PtrTo(typ).Implements(scannerType) {
+		pointer := reflect.New(typ)
+		if err := pointer.Interface().(sql.Scanner).Scan(value); err != nil {
+			return reflect.Value{}, err
+		}
+
+		return pointer.Elem(), nil
+	}
+
+	// Converting a number to a string will produce a rune rather than the number, so only allow
+	// strings to be converted to other strings.
+	if reflection.Type().ConvertibleTo(typ) && (reflection.Kind() == reflect.String) == (typ.Kind() == reflect.String) {
+		if err := checkNumberConversion(reflection, typ); err != nil {
+			return reflect.Value{}, err
+		}
+
+		return reflection.Convert(typ), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot convert %T to %s", value, typ)
+}
+
+// lossyConversionError is returned when a number used in a filter cannot be represented by the
+// type of the field without changing its value.
+type lossyConversionError struct {
+	value interface{}
+	typ   reflect.Type
+
+	// outside is -1 when the value is less than every value of the type and 1 when it is greater
+	// than every value of the type. It is 0 when the value is within the range of the type but
+	// cannot be represented by it, like a fraction for an integer type.
+	outside int
+}
+
+func (e *lossyConversionError) Error() string {
+	switch e.outside {
+	case 0:
+		return fmt.Sprintf("%v cannot be represented by %s", e.value, e.typ)
+	default:
+		return fmt.Sprintf("%v is out of the range of %s", e.value, e.typ)
+	}
+}
+
+// checkNumberConversion returns a *lossyConversionError if converting the number to the provided
+// type would truncate or wrap it. Values that are not numbers are not checked.
+func checkNumberConversion(value reflect.Value, typ reflect.Type) error {
+	lossy := func(outside int) error {
+		return &lossyConversionError{value: value.Interface(), typ: typ, outside: outside}
+	}
+
+	target := reflect.New(typ).Elem()
+	switch {
+	case isSignedKind(typ.Kind()):
+		switch {
+		case isSignedKind(value.Kind()):
+			if target.OverflowInt(value.Int()) {
+				return lossy(sign(value.Int()))
+			}
+		case isUnsignedKind(value.Kind()):
+			if value.Uint() > math.MaxInt64 || target.OverflowInt(int64(value.Uint())) {
+				return lossy(1)
+			}
+		case isFloatKind(value.Kind()):
+			float := value.Float()
+			switch {
+			case float < math.MinInt64 || (float >= 0 && float >= math.MaxInt64) || math.IsNaN(float):
+				return lossy(signFloat(float))
+			case float != math.Trunc(float):
+				return lossy(0)
+			case target.OverflowInt(int64(float)):
+				return lossy(signFloat(float))
+			}
+		}
+	case isUnsignedKind(typ.Kind()):
+		switch {
+		case isSignedKind(value.Kind()):
+			if value.Int() < 0 {
+				return lossy(-1)
+			} else if target.OverflowUint(uint64(value.Int())) {
+				return lossy(1)
+			}
+		case isUnsignedKind(value.Kind()):
+			if target.OverflowUint(value.Uint()) {
+				return lossy(1)
+			}
+		case isFloatKind(value.Kind()):
+			float := value.Float()
+			switch {
+			case float < 0:
+				return lossy(-1)
+			case float >= math.MaxUint64 || math.IsNaN(float):
+				return lossy(1)
+			case float != math.Trunc(float):
+				return lossy(0)
+			case target.OverflowUint(uint64(float)):
+				return lossy(1)
+			}
+		}
+	case isFloatKind(typ.Kind()) && isFloatKind(value.Kind()):
+		if target.OverflowFloat(value.Float()) {
+			return lossy(signFloat(value.Float()))
+		}
+	}
+
+	return nil
+}
+
+func isSignedKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	default:
+		return false
+	}
+}
+
+func isUnsignedKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	default:
+		return false
+	}
+}
+
+func isFloatKind(kind reflect.Kind) bool {
+	return kind == reflect.Float32 || kind == reflect.Float64
+}
+
+func sign(value int64) int {
+	if value < 0 {
+		return -1
+	}
+
+	return 1
+}
+
+func signFloat(value float64) int {
+	if value < 0 {
+		return -1
+	}
+
+	return 1
+}
+
+// comparableValue dereferences pointers and unwraps driver.Valuer types so that the underlying
+// value can be compared against a filter. The value must not be null.
+func comparableValue(value reflect.Value) interface{} {
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	if valuer, ok := value.Interface().(driver.Valuer); ok {
+		if driverValue, err := valuer.Value(); err == nil {
+			return driverValue
+		}
+	}
+
+	return value.Interface()
+}
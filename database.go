@@ -1,22 +1,130 @@
 package mellivora
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/elliotcourant/meles"
 	"github.com/elliotcourant/timber"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxAttempts = 5
+
+	minRetryBackoff = 10 * time.Millisecond
+	maxRetryBackoff = time.Second
 )
 
 type Database struct {
 	store  *meles.Store
 	logger timber.Logger
+
+	modelsLock sync.RWMutex
+	models     map[reflect.Type]Model
+
+	// checks are the check constraints registered for each model, they are guarded by the
+	// modelsLock.
+	checks map[reflect.Type][]checkConstraint
+
+	// maxAttempts is the number of times RunInTransaction will try a transaction that conflicts.
+	maxAttempts int
 }
 
 func NewDatabase(store *meles.Store, logger timber.Logger) *Database {
 	return &Database{
-		store:  store,
-		logger: logger,
+		store:       store,
+		logger:      logger,
+		maxAttempts: defaultMaxAttempts,
 	}
 }
 
+// SetMaxAttempts sets the number of times RunInTransaction will try a transaction before returning
+// ErrConflict, it defaults to 5.
+func (db *Database) SetMaxAttempts(attempts int) {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	db.maxAttempts = attempts
+}
+
+// RegisterModel validates each of the provided models and registers them with the database. Models
+// are also registered the first time they are used, registering them up front allows problems with
+// a model to be found when the application starts rather than when the model is first used.
+func (db *Database) RegisterModel(models ...interface{}) error {
+	for _, model := range models {
+		if _, err := db.getModel(model); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateModel returns an error describing every problem with the definition of the model, if
+// there are any. The model is not registered with the database.
+func (db *Database) ValidateModel(model interface{}) error {
+	_, err := getModelInfo(model)
+	return err
+}
+
+// getModel returns the metadata for the provided model and registers it with the database if it
+// has not been used before.
+func (db *Database) getModel(model interface{}) (Model, error) {
+	info, err := getModelInfo(model)
+	if err != nil {
+		return nil, err
+	}
+
+	db.modelsLock.RLock()
+	_, ok := db.models[info.Type()]
+	db.modelsLock.RUnlock()
+	if ok {
+		return info, nil
+	}
+
+	db.modelsLock.Lock()
+	defer db.modelsLock.Unlock()
+	if db.models == nil {
+		db.models = map[reflect.Type]Model{}
+	}
+
+	// Two models with the same Id would read and overwrite each other's records. Types declared in
+	// different functions can have the same package and name, which cannot be told apart.
+	for typ, other := range db.models {
+		if typ != info.Type() && other.(*modelInfo).identity == info.(*modelInfo).identity && !isNamedModel(info) {
+			return nil, &ModelError{
+				Model: info.Name(),
+				Kind:  ModelErrorAmbiguousModel,
+				Message: fmt.Sprintf("another type named %s is already used as a model, give one of them a name with ModelName or the model tag",
+					typ),
+			}
+		}
+
+		if typ != info.Type() && other.ModelId() == info.ModelId() {
+			return nil, &ModelError{
+				Model: info.Name(),
+				Kind:  ModelErrorDuplicateModelId,
+				Message: fmt.Sprintf("%s has the same Id %d, give one of them a different name with ModelName or the model tag",
+					typ, info.ModelId()),
+			}
+		}
+	}
+	db.models[info.Type()] = info
+
+	return info, nil
+}
+
+// isNamedModel returns true if the model declares its own name rather than using the package and
+// name of its type.
+func isNamedModel(model Model) bool {
+	return model.(*modelInfo).identity != fmt.Sprint(model.Type().PkgPath(), model.Type().Name())
+}
+
 func (db *Database) Begin() (*Transaction, error) {
 	storeTxn, err := db.store.Begin()
 	if err != nil {
@@ -28,3 +136,87 @@ func (db *Database) Begin() (*Transaction, error) {
 		tx: storeTxn,
 	}, err
 }
+
+// BeginReadOnly starts a transaction that can only read records. Insert, Update and Delete return
+// ErrReadOnly, and since nothing is written it never conflicts with, or causes a conflict for,
+// any other transaction.
+func (db *Database) BeginReadOnly() (*Transaction, error) {
+	return db.BeginReadOnlyAt(time.Now())
+}
+
+// BeginReadOnlyAt starts a read only transaction that reads records as they were at the provided
+// time. Changes committed after that time are not visible to it.
+func (db *Database) BeginReadOnlyAt(timestamp time.Time) (*Transaction, error) {
+	storeTxn, err := db.store.BeginAt(timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transaction{
+		db:       db,
+		tx:       storeTxn,
+		readOnly: true,
+	}, nil
+}
+
+// RunInTransaction calls fn with a new transaction and commits it if fn does not return an error.
+// If fn returns an error or panics then the transaction is rolled back. When the transaction
+// conflicts with another one it is retried with a new transaction after a short backoff, until the
+// max attempts set with SetMaxAttempts is reached or the context is done. Since fn may be called
+// more than once it should not have side effects outside of the transaction.
+func (db *Database) RunInTransaction(ctx context.Context, fn func(txn *Transaction) error) error {
+	maxAttempts := db.maxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	backoff := minRetryBackoff
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := db.runTransaction(fn)
+		if err == nil || !errors.Is(err, ErrConflict) || attempt >= maxAttempts {
+			return err
+		}
+
+		db.logger.Debugf("transaction conflicted on attempt %d of %d, retrying", attempt, maxAttempts)
+
+		// Jitter keeps transactions that conflicted with each other from retrying at the same
+		// time.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// runTransaction makes a single attempt of RunInTransaction.
+func (db *Database) runTransaction(fn func(txn *Transaction) error) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// The deferred rollback also runs when fn panics, the panic then continues up the stack.
+	committed := false
+	defer func() {
+		if !committed {
+			_ = txn.Rollback()
+		}
+	}()
+
+	if err := fn(txn); err != nil {
+		return err
+	}
+
+	committed = true
+	return txn.Commit()
+}
@@ -0,0 +1,237 @@
+package mellivora
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDatabase_ValidateModel(t *testing.T) {
+	db := &Database{}
+
+	t.Run("valid", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk,serial"`
+			Name   string `m:"uq"`
+		}
+
+		assert.NoError(t, db.ValidateModel(Item{}))
+		assert.NoError(t, db.RegisterModel(Item{}, &Item{}))
+	})
+
+	t.Run("not a struct", func(t *testing.T) {
+		err := db.ValidateModel(1)
+		assert.Error(t, err)
+		assert.Equal(t, ModelErrorUnsupportedType, err.(*ModelError).Kind)
+	})
+
+	t.Run("every problem is reported", func(t *testing.T) {
+		type Parent struct {
+			ParentId uint64 `m:"pk"`
+		}
+
+		type Item struct {
+			ParentId uint64
+			Parent   Parent `m:"fk:ParentIdentifier"`
+			Callback func()
+			Name     string `m:"uq,nullable"`
+			Other    string `m:"uq:uq_name"`
+		}
+
+		err := db.RegisterModel(Item{})
+		assert.Error(t, err)
+
+		errs, ok := err.(ModelErrors)
+		assert.True(t, ok)
+
+		kinds := make([]ModelErrorKind, len(errs))
+		for i, item := range errs {
+			kinds[i] = item.Kind
+		}
+		assert.Equal(t, []ModelErrorKind{
+			ModelErrorUnsupportedField,
+			ModelErrorUnknownTag,
+			ModelErrorMissingPrimaryKey,
+			ModelErrorUnknownForeignKey,
+			ModelErrorDuplicateConstraint,
+		}, kinds)
+
+		assert.Equal(t, "Callback", errs[0].Field)
+		assert.Equal(t, "model Item field [Name]: unknown tag: [nullable] is not a known tag", errs[1].Error())
+		assert.Equal(t, "", errs[2].Field)
+		assert.Equal(t, "Parent", errs[3].Field)
+	})
+
+	t.Run("index conflicts with unique constraint", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+			Name   string `m:"uq"`
+			Other  string `m:"index:uq_name"`
+		}
+
+		err := db.ValidateModel(Item{})
+		assert.Error(t, err)
+		assert.Equal(t, ModelErrorDuplicateConstraint, err.(ModelErrors)[0].Kind)
+	})
+}
+
+func TestDatabase_RegisterModel(t *testing.T) {
+	t.Run("types with the same name", func(t *testing.T) {
+		db := &Database{}
+		{
+			type Node struct {
+				NodeId uint64 `m:"pk"`
+			}
+			assert.NoError(t, db.RegisterModel(Node{}))
+		}
+		{
+			type Node struct {
+				NodeId  uint64 `m:"pk"`
+				Address string
+			}
+			err := db.RegisterModel(Node{})
+			assert.EqualError(t, err, "model Node: ambiguous model: another type named mellivora.Node is already used as a model, "+
+				"give one of them a name with ModelName or the model tag")
+			assert.Equal(t, ModelErrorAmbiguousModel, err.(*ModelError).Kind)
+		}
+		{
+			type Node struct {
+				_       struct{} `m:"model:other_node"`
+				NodeId  uint64   `m:"pk"`
+				Address string
+			}
+			assert.NoError(t, db.RegisterModel(Node{}), "named types can share a name with other types")
+		}
+	})
+
+	t.Run("same model name", func(t *testing.T) {
+		type Server struct {
+			_        struct{} `m:"model:data_node"`
+			ServerId uint64   `m:"pk"`
+		}
+
+		db := &Database{}
+		assert.NoError(t, db.RegisterModel(testNamedNode{}, &testNamedNode{}))
+
+		err := db.RegisterModel(Server{})
+		assert.EqualError(t, err, "model Server: duplicate model id: mellivora.testNamedNode has the same Id 2175754108, "+
+			"give one of them a different name with ModelName or the model tag")
+	})
+}
+
+func TestDatabase_RunInTransaction(t *testing.T) {
+	type Counter struct {
+		CounterId uint64 `m:"pk"`
+		Value     int
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	err := db.RunInTransaction(context.Background(), func(txn *Transaction) error {
+		return txn.Insert(Counter{CounterId: 1})
+	})
+	assert.NoError(t, err)
+
+	// conflict updates the counter in another transaction so that the transaction that read it
+	// cannot be committed.
+	conflict := func(value int) {
+		txn, err := db.Begin()
+		assert.NoError(t, err)
+		assert.NoError(t, txn.Update(&Counter{CounterId: 1, Value: value}))
+		assert.NoError(t, txn.Commit())
+	}
+
+	getValue := func() int {
+		txn, err := db.Begin()
+		assert.NoError(t, err)
+		defer txn.Rollback()
+
+		counters := make([]Counter, 0)
+		assert.NoError(t, txn.Model(counters).Select(&counters))
+		if assert.Len(t, counters, 1) {
+			return counters[0].Value
+		}
+
+		return 0
+	}
+
+	t.Run("retries conflicts", func(t *testing.T) {
+		attempts := 0
+		err := db.RunInTransaction(context.Background(), func(txn *Transaction) error {
+			attempts++
+			if err := txn.Update(&Counter{CounterId: 1, Value: 10}); err != nil {
+				return err
+			}
+
+			if attempts == 1 {
+				conflict(5)
+			}
+
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+		assert.Equal(t, 10, getValue())
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		db.SetMaxAttempts(3)
+		defer db.SetMaxAttempts(defaultMaxAttempts)
+
+		attempts := 0
+		err := db.RunInTransaction(context.Background(), func(txn *Transaction) error {
+			attempts++
+			if err := txn.Update(&Counter{CounterId: 1, Value: 20}); err != nil {
+				return err
+			}
+
+			conflict(attempts)
+			return nil
+		})
+		assert.Equal(t, ErrConflict, err)
+		assert.Equal(t, 3, attempts)
+		assert.Equal(t, 3, getValue())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		attempts := 0
+		err := db.RunInTransaction(context.Background(), func(txn *Transaction) error {
+			attempts++
+			assert.NoError(t, txn.Update(&Counter{CounterId: 1, Value: 30}))
+			return errors.New("something went wrong")
+		})
+		assert.EqualError(t, err, "something went wrong")
+		assert.Equal(t, 1, attempts)
+		assert.Equal(t, 3, getValue())
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		assert.PanicsWithValue(t, "something went wrong", func() {
+			_ = db.RunInTransaction(context.Background(), func(txn *Transaction) error {
+				assert.NoError(t, txn.Update(&Counter{CounterId: 1, Value: 40}))
+				panic("something went wrong")
+			})
+		})
+		assert.Equal(t, 3, getValue())
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		attempts := 0
+		err := db.RunInTransaction(ctx, func(txn *Transaction) error {
+			attempts++
+			if err := txn.Update(&Counter{CounterId: 1, Value: 50}); err != nil {
+				return err
+			}
+
+			conflict(6)
+			cancel()
+			return nil
+		})
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 1, attempts)
+		assert.Equal(t, 6, getValue())
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/elliotcourant/buffers"
 	"reflect"
+	"sort"
+	"time"
 )
 
 var (
@@ -19,14 +21,22 @@ type (
 		Keys() (map[string][]byte, error)
 		Verify() (map[string]bool, error)
 		DatumPrefix() []byte
+		UniquePrefix(uniqueConstraint UniqueConstraint) []byte
+		IndexPrefix(index Index) []byte
 	}
 
 	datumBuilderBase struct {
 		model    Model
 		value    reflect.Value
 		isInsert bool
+		now      time.Time
 		datums   map[string][]byte
 		verify   map[string]bool
+
+		// row is the index of the record being encoded, and rows is the index of the record that
+		// wrote each key. They are used to name both records when a batch writes a key twice.
+		row  int
+		rows map[string]int
 	}
 
 	datumReader interface {
@@ -56,25 +66,30 @@ func (d *datumReaderBase) Read(key, value []byte) (reflect.Value, error) {
 
 	// Read type prefix
 	if kvType := keyReader.NextByte(); kvType != datumKeyPrefix {
-		// TODO (elliotcourant) parse the type prefix and try to stringify it.
-		return reflection, fmt.Errorf("key value pair is not a datum")
+		return reflection, fmt.Errorf("key [%s] is not a datum", Key(key))
 	}
 
 	// Make sure the modelId matches what we are trying to read.
 	if modelId := keyReader.NextUint32(); modelId != d.Model().ModelId() {
-		// TODO (elliotcourant) try to find out what type this datum is
-		//  for the sake of returning a helpful error.
+		found := fmt.Sprintf("model Id %d", modelId)
+		if model, _ := models.getModelById(modelId); model != nil {
+			found = model.Name()
+		}
+
 		return reflection, fmt.Errorf(
-			"datum is not [%s], expected modelId: %d found: %d",
-			d.Model().Name(), d.Model().ModelId(), modelId)
+			"datum [%s] is not [%s], it is %s", Key(key), d.Model().Name(), found)
 	}
 
 	primaryKeyFields := d.Model().PrimaryKey().GetAll()
 	for _, field := range primaryKeyFields {
-		kind := field.Reflection().Type.Kind()
-		reflection.
-			FieldByIndex(field.Reflection().Index).
-			Set(reflect.ValueOf(keyReader.NextReflection(kind)))
+		codec, err := getFieldCodec(field)
+		if err != nil {
+			return reflection, err
+		}
+
+		if err := codec.ReadKey(keyReader, reflection.FieldByIndex(field.Reflection().Index)); err != nil {
+			return reflection, err
+		}
 	}
 
 	valueReader := buffers.NewBytesReader(value)
@@ -82,19 +97,20 @@ func (d *datumReaderBase) Read(key, value []byte) (reflect.Value, error) {
 	datumFields := d.Model().Fields().GetAll()
 	for _, field := range datumFields {
 		// Skip primary key fields since we already read those from the key.
-		if field.IsPrimaryKey() || field.Reflection().Type.Kind() == reflect.Struct {
+		if field.IsPrimaryKey() {
+			continue
+		}
+
+		codec, err := getFieldCodec(field)
+		if err != nil {
+			return reflection, err
+		} else if codec == nil {
 			continue
 		}
 
-		kind := field.Reflection().Type.Kind()
-		fieldReflection := reflection.
-			FieldByIndex(field.Reflection().Index)
-		// Even though we can probably set the field based on the base type we want to do this just
-		// in case the target is a custom type based on a normal kind.
-		readValue := reflect.
-			ValueOf(valueReader.NextReflection(kind)).
-			Convert(fieldReflection.Type())
-		fieldReflection.Set(readValue)
+		if err := codec.Read(valueReader, reflection.FieldByIndex(field.Reflection().Index)); err != nil {
+			return reflection, err
+		}
 	}
 
 	return reflection, nil
@@ -105,24 +121,54 @@ func newDatumBuilder(model Model, value reflect.Value, isInsert bool) datumBuild
 		model:    model,
 		value:    value,
 		isInsert: isInsert,
+		now:      time.Now(),
 		datums:   map[string][]byte{},
 		verify:   map[string]bool{},
+		rows:     map[string]int{},
 	}
 }
 
 func (d *datumBuilderBase) setDatum(key, value []byte) error {
 	if _, ok := d.datums[string(key)]; ok {
-		return fmt.Errorf("an item with the key [%s] already exists in this datumset", string(key))
+		return d.duplicate(key)
 	}
 
 	d.datums[string(key)] = value
+	d.rows[string(key)] = d.row
 
 	return nil
 }
 
+// duplicate returns the error for a key that is written by two records of the same batch.
+func (d *datumBuilderBase) duplicate(key []byte) error {
+	return d.duplicateOf(string(key), d.rows[string(key)], d.row)
+}
+
+// duplicateOf returns the error for a key that is written by both of the provided rows.
+func (d *datumBuilderBase) duplicateOf(key string, first, second int) error {
+	rows := []int{first, second}
+	switch violation := keyViolation(d.model, []byte(key)).(type) {
+	case *PrimaryKeyViolation:
+		return &BatchDuplicate{
+			Model:  d.model.Name(),
+			Rows:   rows,
+			Values: violation.Values,
+		}
+	case *UniqueViolation:
+		return &BatchDuplicate{
+			Model:      d.model.Name(),
+			Constraint: violation.Constraint,
+			Rows:       rows,
+			Values:     violation.Values,
+		}
+	default:
+		return violation
+	}
+}
+
 func (d *datumBuilderBase) setVerify(key []byte, canExist bool) error {
 	if _, ok := d.verify[string(key)]; ok {
-		return fmt.Errorf("an verify with the key [%s] already exists in this datumset", string(key))
+		return fmt.Errorf("an verify with the key [%s] already exists in this datumset", Key(key))
 	}
 
 	d.verify[string(key)] = canExist
@@ -153,6 +199,7 @@ func (d *datumBuilderBase) Keys() (map[string][]byte, error) {
 	case reflect.Slice, reflect.Array:
 		numItems := value.Len()
 		for i := 0; i < numItems; i++ {
+			d.row = i
 			if err := d.encodeSingleDatum(value.Index(i)); err != nil {
 				return nil, err
 			}
@@ -173,18 +220,41 @@ func (d *datumBuilderBase) DatumPrefix() []byte {
 	return datumKeyBuf.Bytes()
 }
 
+func (d *datumBuilderBase) UniquePrefix(uniqueConstraint UniqueConstraint) []byte {
+	uniqueKeyBuf := buffers.NewBytesBuffer()
+	uniqueKeyBuf.AppendByte(uniqueKeyPrefix)
+	uniqueKeyBuf.AppendUint32(d.model.ModelId())
+	uniqueKeyBuf.AppendUint32(uniqueConstraint.UniqueConstraintId())
+	return uniqueKeyBuf.Bytes()
+}
+
+func (d *datumBuilderBase) IndexPrefix(index Index) []byte {
+	indexKeyBuf := buffers.NewBytesBuffer()
+	indexKeyBuf.AppendByte(indexKeyPrefix)
+	indexKeyBuf.AppendUint32(d.model.ModelId())
+	indexKeyBuf.AppendUint32(index.IndexId())
+	return indexKeyBuf.Bytes()
+}
+
 func (d *datumBuilderBase) encodeSingleDatum(value reflect.Value) error {
 	for value.Kind() == reflect.Ptr {
 		value = value.Elem()
 	}
 
+	if d.isInsert {
+		value = applyDefaults(d.model, value)
+		value = applyTimestamps(d.model, value, d.now)
+	}
+
+	primaryKey, err := encodePrimaryKey(d.model, value)
+	if err != nil {
+		return err
+	}
+	primaryKeyValueBuf := buffers.NewBytesBuffer()
+	primaryKeyValueBuf.AppendRaw(primaryKey)
+
 	// Handle initial datum record.
 	{
-		primaryKeyValueBuf := buffers.NewBytesBuffer()
-		for _, fieldInfo := range d.model.PrimaryKey().GetAll() {
-			primaryKeyValueBuf.AppendReflection(value.FieldByIndex(fieldInfo.Reflection().Index))
-		}
-
 		datumKeyBuf := buffers.NewBytesBuffer()
 		datumKeyBuf.AppendByte(datumKeyPrefix)
 		datumKeyBuf.AppendUint32(d.model.ModelId())
@@ -192,10 +262,20 @@ func (d *datumBuilderBase) encodeSingleDatum(value reflect.Value) error {
 
 		datumValueBuf := buffers.NewBytesBuffer()
 		for _, fieldInfo := range d.model.Fields().GetAll() {
-			if fieldInfo.IsPrimaryKey() || fieldInfo.Reflection().Type.Kind() == reflect.Struct {
+			if fieldInfo.IsPrimaryKey() {
 				continue
 			}
-			datumValueBuf.AppendReflection(value.FieldByIndex(fieldInfo.Reflection().Index))
+
+			codec, err := getFieldCodec(fieldInfo)
+			if err != nil {
+				return err
+			} else if codec == nil {
+				continue
+			}
+
+			if err := codec.Append(datumValueBuf, value.FieldByIndex(fieldInfo.Reflection().Index)); err != nil {
+				return err
+			}
 		}
 
 		datumKey := datumKeyBuf.Bytes()
@@ -215,15 +295,37 @@ func (d *datumBuilderBase) encodeSingleDatum(value reflect.Value) error {
 
 	for _, uniqueConstraint := range d.model.UniqueConstraints().GetAll() {
 		uniqueConstraintBuf := buffers.NewBytesBuffer()
-		uniqueConstraintBuf.AppendByte(uniqueKeyPrefix)
-		uniqueConstraintBuf.AppendUint32(d.model.ModelId())
-		uniqueConstraintBuf.AppendUint32(uniqueConstraint.UniqueConstraintId())
+		uniqueConstraintBuf.AppendRaw(d.UniquePrefix(uniqueConstraint))
+
+		// Like SQL, nulls are considered distinct from one another. So if any of the fields in the
+		// constraint are null then there is nothing to enforce for this constraint.
+		hasNull := false
 		for _, fieldInfo := range uniqueConstraint.Fields().GetAll() {
-			uniqueConstraintBuf.AppendReflection(value.FieldByIndex(fieldInfo.Reflection().Index))
+			fieldValue := value.FieldByIndex(fieldInfo.Reflection().Index)
+			if isNullValue(fieldValue) {
+				hasNull = true
+				break
+			}
+
+			codec, err := getFieldCodec(fieldInfo)
+			if err != nil {
+				return err
+			}
+
+			if err := codec.AppendKey(uniqueConstraintBuf, fieldValue); err != nil {
+				return err
+			}
+		}
+
+		if hasNull {
+			continue
 		}
+
 		uniqueConstraintKey := uniqueConstraintBuf.Bytes()
 
-		if err := d.setDatum(uniqueConstraintKey, make([]byte, 0)); err != nil {
+		// The primary key is stored as the value so that a record can be found by its unique
+		// values.
+		if err := d.setDatum(uniqueConstraintKey, primaryKeyValueBuf.Bytes()); err != nil {
 			return err
 		}
 
@@ -233,9 +335,169 @@ func (d *datumBuilderBase) encodeSingleDatum(value reflect.Value) error {
 		}
 	}
 
+	for _, index := range d.model.Indexes().GetAll() {
+		indexBuf := buffers.NewBytesBuffer()
+		indexBuf.AppendRaw(d.IndexPrefix(index))
+
+		// Unlike unique constraints nulls are still written to the index, they will sort before
+		// any other values.
+		for _, fieldInfo := range index.Fields().GetAll() {
+			codec, err := getFieldCodec(fieldInfo)
+			if err != nil {
+				return err
+			}
+
+			if err := codec.AppendKey(indexBuf, value.FieldByIndex(fieldInfo.Reflection().Index)); err != nil {
+				return err
+			}
+		}
+
+		// The primary key is included in the index key so that multiple records can have the same
+		// indexed values. It is also stored as the value so that the datum can be found without
+		// decoding the indexed values.
+		indexBuf.AppendRaw(primaryKeyValueBuf.Bytes())
+
+		if err := d.setDatum(indexBuf.Bytes(), primaryKeyValueBuf.Bytes()); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// sortedKeys returns the keys of the datums in order, so that they are written in the same order
+// every time.
+func sortedKeys(datums map[string][]byte) []string {
+	keys := make([]string, 0, len(datums))
+	for key := range datums {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// encodePrimaryKey returns the key encoding of the primary key fields of the provided record.
+func encodePrimaryKey(model Model, value reflect.Value) ([]byte, error) {
+	buf := buffers.NewBytesBuffer()
+	for _, fieldInfo := range model.PrimaryKey().GetAll() {
+		fieldValue := value.FieldByIndex(fieldInfo.Reflection().Index)
+		if isNullValue(fieldValue) {
+			return nil, fmt.Errorf("primary key field [%s] cannot be null", fieldInfo.Name())
+		}
+
+		codec, err := getFieldCodec(fieldInfo)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := codec.AppendKey(buf, fieldValue); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// applyDefaults sets the default of every field that has one and has its zero value.
+func applyDefaults(model Model, value reflect.Value) reflect.Value {
+	for _, field := range model.Fields().GetAll() {
+		defaultValue := field.(*modelField).defaultValue
+		if !defaultValue.IsValid() {
+			continue
+		}
+
+		if isZeroValue(value.FieldByIndex(field.Reflection().Index)) {
+			value = setField(value, field, defaultValue)
+		}
+	}
+
+	return value
+}
+
+// applyTimestamps sets the created at and updated at fields of a record that is being inserted.
+// The created at field is only set if it does not already have a value.
+func applyTimestamps(model Model, value reflect.Value, now time.Time) reflect.Value {
+	info := model.(*modelInfo)
+	if info.createdAt != nil && isZeroValue(value.FieldByIndex(info.createdAt.Reflection().Index)) {
+		value = setField(value, info.createdAt, timestampValue(info.createdAt, now))
+	}
+
+	if info.updatedAt != nil {
+		value = setField(value, info.updatedAt, timestampValue(info.updatedAt, now))
+	}
+
+	return value
+}
+
+// timestampValue returns the time as a value that can be set on the provided timestamp field,
+// which is either a time.Time or a *time.Time.
+func timestampValue(field Field, now time.Time) reflect.Value {
+	if field.Reflection().Type.Kind() == reflect.Ptr {
+		return reflect.ValueOf(&now)
+	}
+
+	return reflect.ValueOf(now)
+}
+
+// setField sets the field of the record to the provided value. If the record cannot be set, like
+// when a model is inserted by value, then a copy of the record is returned with the field set.
+func setField(record reflect.Value, field Field, value reflect.Value) reflect.Value {
+	if !record.CanSet() {
+		copied := reflect.New(record.Type()).Elem()
+		copied.Set(record)
+		record = copied
+	}
+
+	record.FieldByIndex(field.Reflection().Index).Set(value)
+	return record
+}
+
+// getVersion returns the value of the version field of the record.
+func getVersion(field Field, record reflect.Value) uint64 {
+	value := record.FieldByIndex(field.Reflection().Index)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(value.Int())
+	default:
+		return value.Uint()
+	}
+}
+
+// versionValue returns the version as a value that can be set on the provided version field.
+func versionValue(field Field, version uint64) reflect.Value {
+	value := reflect.New(field.Reflection().Type).Elem()
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value.SetInt(int64(version))
+	default:
+		value.SetUint(version)
+	}
+
+	return value
+}
+
+// isDeleted returns true if the model has a deleted at field and it is set on the record.
+func isDeleted(model Model, value reflect.Value) bool {
+	deletedAt := model.(*modelInfo).deletedAt
+	if deletedAt == nil {
+		return false
+	}
+
+	fieldValue := value.FieldByIndex(deletedAt.Reflection().Index)
+	return !isNullValue(fieldValue) && !isZeroValue(fieldValue)
+}
+
+// isZeroValue returns true if the value is the zero value of its type.
+func isZeroValue(value reflect.Value) bool {
+	// Times in different locations can be the zero time without being equal to time.Time{}.
+	if value.Type() == timeType {
+		return value.Interface().(time.Time).IsZero()
+	}
+
+	return reflect.DeepEqual(value.Interface(), reflect.Zero(value.Type()).Interface())
+}
+
 func (d *datumBuilderBase) Verify() (map[string]bool, error) {
 	// If we have already built our datum set then we know the verify set has been built.
 	if len(d.datums) > 0 {
@@ -248,3 +510,18 @@ func (d *datumBuilderBase) Verify() (map[string]bool, error) {
 
 	return d.verify, err
 }
+
+// getFieldCodec returns the codec used to store the provided field. Relations are not stored as
+// part of the datum, nil is returned for those fields.
+func getFieldCodec(field Field) (columnCodec, error) {
+	info := field.(*modelField)
+	if info.isRelation {
+		return nil, nil
+	}
+
+	if info.codec == nil {
+		return nil, fmt.Errorf("field [%s] of type %s cannot be stored", field.Name(), field.Reflection().Type)
+	}
+
+	return info.codec, nil
+}
@@ -1,9 +1,14 @@
 package mellivora
 
 import (
+	"database/sql"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestDatumBuilderBase_Keys(t *testing.T) {
@@ -26,7 +31,8 @@ func TestDatumBuilderBase_Keys(t *testing.T) {
 			Healthy:    true,
 		}
 
-		info := getModelInfo(dataNode)
+		info, err := getModelInfo(dataNode)
+		assert.NoError(t, err)
 
 		builder := newDatumBuilder(info, reflect.ValueOf(dataNode), true)
 		datums, err := builder.Keys()
@@ -67,7 +73,8 @@ func TestDatumBuilderBase_Keys(t *testing.T) {
 			},
 		}
 
-		info := getModelInfo(dataNode)
+		info, err := getModelInfo(dataNode)
+		assert.NoError(t, err)
 
 		builder := newDatumBuilder(info, reflect.ValueOf(dataNode), true)
 		datums, err := builder.Keys()
@@ -108,12 +115,87 @@ func TestDatumBuilderBase_Keys(t *testing.T) {
 			},
 		}
 
-		info := getModelInfo(dataNode)
+		info, err := getModelInfo(dataNode)
+		assert.NoError(t, err)
 
 		builder := newDatumBuilder(info, reflect.ValueOf(dataNode), true)
-		_, err := builder.Keys()
+		_, err = builder.Keys()
 		assert.Error(t, err, "expected error due to unique violation")
 	})
+	t.Run("unique with nulls", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64  `m:"pk"`
+			Code   *string `m:"uq"`
+		}
+
+		items := []Item{
+			{
+				ItemId: 1,
+			},
+			{
+				ItemId: 2,
+			},
+		}
+
+		info, err := getModelInfo(items)
+		assert.NoError(t, err)
+
+		builder := newDatumBuilder(info, reflect.ValueOf(items), true)
+		datums, err := builder.Keys()
+		assert.NoError(t, err, "nulls should not violate a unique constraint")
+		assert.Len(t, datums, 2)
+	})
+
+	t.Run("null primary key", func(t *testing.T) {
+		type Item struct {
+			ItemId *uint64 `m:"pk"`
+			Name   string
+		}
+
+		info, err := getModelInfo(Item{})
+		assert.NoError(t, err)
+
+		builder := newDatumBuilder(info, reflect.ValueOf(Item{}), true)
+		_, err = builder.Keys()
+		assert.Error(t, err, "primary keys cannot be null")
+	})
+	t.Run("unique time", func(t *testing.T) {
+		type Event struct {
+			EventId    [16]byte  `m:"pk"`
+			OccurredAt time.Time `m:"uq"`
+		}
+
+		occurredAt := time.Date(2019, 12, 1, 4, 21, 0, 0, time.UTC)
+		events := []Event{
+			{
+				EventId:    [16]byte{1},
+				OccurredAt: occurredAt,
+			},
+			{
+				EventId:    [16]byte{2},
+				OccurredAt: occurredAt.In(time.FixedZone("CST", -6*60*60)),
+			},
+		}
+
+		info, err := getModelInfo(events)
+		assert.NoError(t, err)
+
+		builder := newDatumBuilder(info, reflect.ValueOf(events), true)
+		_, err = builder.Keys()
+		assert.Error(t, err, "the same instant in another location should violate the constraint")
+	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+			Other  chan int
+		}
+
+		// The model itself is not valid, so it is rejected before anything can be encoded.
+		_, err := getModelInfo(Item{})
+		assert.Error(t, err)
+		assert.Equal(t, ModelErrorUnsupportedField, err.(ModelErrors)[0].Kind)
+	})
 }
 
 func TestDatumReaderBase_Read(t *testing.T) {
@@ -142,7 +224,8 @@ func TestDatumReaderBase_Read(t *testing.T) {
 			},
 		}
 
-		info := getModelInfo(items)
+		info, err := getModelInfo(items)
+		assert.NoError(t, err)
 
 		builder := newDatumBuilder(info, reflect.ValueOf(items), true)
 		datums, err := builder.Keys()
@@ -160,4 +243,308 @@ func TestDatumReaderBase_Read(t *testing.T) {
 			assert.NotEmpty(t, value)
 		}
 	})
+	t.Run("nullable", func(t *testing.T) {
+		type Item struct {
+			ItemId      uint64 `m:"pk"`
+			Name        *string
+			Description sql.NullString
+			Quantity    sql.NullInt64
+		}
+
+		name := "Item One"
+		items := []Item{
+			{
+				ItemId:      1,
+				Name:        &name,
+				Description: sql.NullString{String: "Something", Valid: true},
+				Quantity:    sql.NullInt64{Int64: 5, Valid: true},
+			},
+			{
+				ItemId: 2,
+			},
+		}
+
+		info, err := getModelInfo(items)
+		assert.NoError(t, err)
+
+		builder := newDatumBuilder(info, reflect.ValueOf(items), true)
+		datums, err := builder.Keys()
+		assert.NoError(t, err)
+		assert.Len(t, datums, 2)
+
+		reader := newDatumReader(info)
+		read := map[uint64]Item{}
+		for k, v := range datums {
+			value, err := reader.Read([]byte(k), v)
+			assert.NoError(t, err)
+			item := value.Interface().(Item)
+			read[item.ItemId] = item
+		}
+
+		assert.Equal(t, items[0], read[1])
+		assert.Equal(t, items[1], read[2])
+		assert.Nil(t, read[2].Name)
+	})
+	t.Run("common types", func(t *testing.T) {
+		type Item struct {
+			ItemId    [16]byte  `m:"pk"`
+			CreatedAt time.Time `m:"uq"`
+			Timeout   time.Duration
+			Balance   big.Int
+			Rate      *big.Float
+			Ratio     big.Rat
+			Weight    float64
+			Score     float32
+		}
+
+		items := []Item{
+			{
+				ItemId:    [16]byte{0x9c, 0x1e, 0x4f, 0x2a, 0x01},
+				CreatedAt: time.Date(2019, 12, 1, 4, 21, 0, 1234, time.UTC),
+				Timeout:   time.Second * 30,
+				Rate:      big.NewFloat(0.125),
+				Weight:    12.5,
+				Score:     -3.25,
+			},
+		}
+		items[0].Balance.SetString("-123456789012345678901234567890", 10)
+		items[0].Ratio.SetFrac64(1, 3)
+
+		info, err := getModelInfo(items)
+		assert.NoError(t, err)
+
+		builder := newDatumBuilder(info, reflect.ValueOf(items), true)
+		datums, err := builder.Keys()
+		assert.NoError(t, err)
+		assert.Len(t, datums, 2)
+
+		reader := newDatumReader(info)
+		for k, v := range datums {
+			if k[0] != datumKeyPrefix {
+				continue
+			}
+
+			value, err := reader.Read([]byte(k), v)
+			assert.NoError(t, err)
+
+			item := value.Interface().(Item)
+			assert.Equal(t, items[0].ItemId, item.ItemId)
+			assert.True(t, items[0].CreatedAt.Equal(item.CreatedAt))
+			assert.Equal(t, items[0].Timeout, item.Timeout)
+			assert.Equal(t, 0, items[0].Balance.Cmp(&item.Balance))
+			assert.Equal(t, 0, items[0].Rate.Cmp(item.Rate))
+			assert.Equal(t, 0, items[0].Ratio.Cmp(&item.Ratio))
+			assert.Equal(t, items[0].Weight, item.Weight)
+			assert.Equal(t, items[0].Score, item.Score)
+		}
+	})
+
+	t.Run("local time is normalized", func(t *testing.T) {
+		type Item struct {
+			ItemId    uint64 `m:"pk"`
+			CreatedAt time.Time
+		}
+
+		item := Item{
+			ItemId:    1,
+			CreatedAt: time.Date(2019, 12, 1, 4, 21, 0, 0, time.FixedZone("CST", -6*60*60)),
+		}
+
+		info, err := getModelInfo(item)
+		assert.NoError(t, err)
+
+		builder := newDatumBuilder(info, reflect.ValueOf(item), true)
+		datums, err := builder.Keys()
+		assert.NoError(t, err)
+
+		reader := newDatumReader(info)
+		for k, v := range datums {
+			value, err := reader.Read([]byte(k), v)
+			assert.NoError(t, err)
+			assert.Equal(t, item.CreatedAt.UTC(), value.Interface().(Item).CreatedAt)
+		}
+	})
+	t.Run("nested", func(t *testing.T) {
+		type Address struct {
+			Street string
+			City   string
+		}
+
+		type Settings struct {
+			Theme         string
+			Notifications bool
+		}
+
+		type Node struct {
+			Name     string
+			Children []Node
+		}
+
+		type Item struct {
+			ItemId    uint64 `m:"pk"`
+			Tags      []string
+			Labels    map[string]string
+			Scores    map[int32]float64
+			Addresses []*Address
+			Primary   Address
+			Tree      Node
+			Checksum  []byte
+			Settings  Settings `m:"json"`
+			Empty     []string
+		}
+
+		item := Item{
+			ItemId: 1,
+			Tags:   []string{"a", "b"},
+			Labels: map[string]string{
+				"env":  "prod",
+				"team": "storage",
+			},
+			Scores: map[int32]float64{
+				1: 1.5,
+				2: 2.5,
+			},
+			Addresses: []*Address{
+				{
+					Street: "1 Main St",
+					City:   "Springfield",
+				},
+				nil,
+			},
+			Primary: Address{
+				Street: "2 Main St",
+				City:   "Shelbyville",
+			},
+			Tree: Node{
+				Name: "root",
+				Children: []Node{
+					{
+						Name: "leaf",
+					},
+				},
+			},
+			Checksum: []byte{0xde, 0xad, 0xbe, 0xef},
+			Settings: Settings{
+				Theme:         "dark",
+				Notifications: true,
+			},
+			Empty: []string{},
+		}
+
+		info, err := getModelInfo(item)
+		assert.NoError(t, err)
+
+		builder := newDatumBuilder(info, reflect.ValueOf(item), true)
+		datums, err := builder.Keys()
+		assert.NoError(t, err)
+		assert.Len(t, datums, 1)
+
+		reader := newDatumReader(info)
+		for k, v := range datums {
+			value, err := reader.Read([]byte(k), v)
+			assert.NoError(t, err)
+			assert.Equal(t, item, value.Interface())
+		}
+	})
+	t.Run("custom codecs", func(t *testing.T) {
+		type Item struct {
+			ItemId  uint64 `m:"pk"`
+			Color   testColor
+			Address testIPAddress `m:"uq"`
+			Backup  *testIPAddress
+		}
+
+		item := Item{
+			ItemId:  1,
+			Color:   testColorBlue,
+			Address: testIPAddress{ip: net.ParseIP("10.0.0.1")},
+		}
+
+		info, err := getModelInfo(item)
+		assert.NoError(t, err)
+
+		builder := newDatumBuilder(info, reflect.ValueOf(item), true)
+		datums, err := builder.Keys()
+		assert.NoError(t, err)
+		assert.Len(t, datums, 2)
+
+		reader := newDatumReader(info)
+		for k, v := range datums {
+			if k[0] != datumKeyPrefix {
+				continue
+			}
+
+			// The color should be stored by its name rather than its number.
+			assert.Contains(t, string(v), "blue")
+
+			value, err := reader.Read([]byte(k), v)
+			assert.NoError(t, err)
+			assert.Equal(t, item.Color, value.Interface().(Item).Color)
+			assert.True(t, item.Address.ip.Equal(value.Interface().(Item).Address.ip))
+			assert.Nil(t, value.Interface().(Item).Backup)
+		}
+	})
+
+	t.Run("custom codec error", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+			Color  testColor
+		}
+
+		item := Item{
+			ItemId: 1,
+			Color:  testColor(42),
+		}
+
+		info, err := getModelInfo(item)
+		assert.NoError(t, err)
+
+		builder := newDatumBuilder(info, reflect.ValueOf(item), true)
+		_, err = builder.Keys()
+		assert.Error(t, err, "the encoder error should be returned")
+	})
+}
+
+type testColor int
+
+const (
+	testColorRed testColor = iota
+	testColorBlue
+)
+
+func (c testColor) EncodeColumn() ([]byte, error) {
+	switch c {
+	case testColorRed:
+		return []byte("red"), nil
+	case testColorBlue:
+		return []byte("blue"), nil
+	default:
+		return nil, fmt.Errorf("invalid color %d", c)
+	}
+}
+
+func (c *testColor) DecodeColumn(src []byte) error {
+	switch string(src) {
+	case "red":
+		*c = testColorRed
+	case "blue":
+		*c = testColorBlue
+	default:
+		return fmt.Errorf("invalid color %s", string(src))
+	}
+
+	return nil
+}
+
+type testIPAddress struct {
+	ip net.IP
+}
+
+func (i testIPAddress) MarshalBinary() ([]byte, error) {
+	return i.ip.To16(), nil
+}
+
+func (i *testIPAddress) UnmarshalBinary(src []byte) error {
+	i.ip = append(net.IP{}, src...)
+	return nil
 }
@@ -0,0 +1,331 @@
+package mellivora
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	_ error = &ModelError{}
+	_ error = ModelErrors{}
+	_ error = &StaleVersionError{}
+	_ error = &ValidationError{}
+	_ error = ValidationErrors{}
+	_ error = &CheckViolation{}
+	_ error = &UniqueViolation{}
+	_ error = &PrimaryKeyViolation{}
+	_ error = &ForeignKeyViolation{}
+	_ error = &BatchDuplicate{}
+	_ error = &RowError{}
+	_ error = BulkInsertErrors{}
+)
+
+var (
+	// ErrNotFound is returned when a record that is being updated or deleted does not exist. The
+	// error returned describes the record, errors.Is can be used to check for it.
+	ErrNotFound = errors.New("record not found")
+
+	// ErrConflict is returned when a transaction cannot be committed because a key it read or
+	// wrote was changed by another transaction. The transaction can be retried.
+	ErrConflict = errors.New("transaction conflict")
+
+	// ErrReadOnly is returned when a read only transaction is used to insert, update or delete
+	// records. errors.Is can be used to check for it.
+	ErrReadOnly = errors.New("transaction is read only")
+
+	// ErrStaleVersion is returned when a record is updated or deleted with a version that does not
+	// match the stored version of the record, meaning the record was changed after it was read. The
+	// error returned is a *StaleVersionError, errors.Is can be used to check for it.
+	ErrStaleVersion = errors.New("stale version")
+)
+
+// ModelErrorKind describes the problem with a model that a ModelError is reporting.
+type ModelErrorKind int
+
+const (
+	// ModelErrorUnsupportedType is returned when the model is not a struct, or a pointer, slice or
+	// array of structs.
+	ModelErrorUnsupportedType ModelErrorKind = iota
+
+	// ModelErrorMissingPrimaryKey is returned when none of the fields of a model are tagged with
+	// pk.
+	ModelErrorMissingPrimaryKey
+
+	// ModelErrorUnsupportedField is returned when a field's type cannot be stored.
+	ModelErrorUnsupportedField
+
+	// ModelErrorUnknownTag is returned when a field has a tag key that is not recognized.
+	ModelErrorUnknownTag
+
+	// ModelErrorInvalidTag is returned when a known tag is used incorrectly.
+	ModelErrorInvalidTag
+
+	// ModelErrorUnknownForeignKey is returned when an fk tag does not name a field of the model.
+	ModelErrorUnknownForeignKey
+
+	// ModelErrorDuplicateConstraint is returned when two unique constraints or indexes have the
+	// same name.
+	ModelErrorDuplicateConstraint
+
+	// ModelErrorInvalidDefault is returned when a default cannot be parsed as the field's type.
+	ModelErrorInvalidDefault
+
+	// ModelErrorDuplicateModelId is returned when a model has the same Id as another model that is
+	// registered with the database.
+	ModelErrorDuplicateModelId
+
+	// ModelErrorAmbiguousModel is returned when a model has the same package and name as another
+	// type that is registered with the database, like types with the same name declared in
+	// different functions.
+	ModelErrorAmbiguousModel
+)
+
+func (k ModelErrorKind) String() string {
+	switch k {
+	case ModelErrorUnsupportedType:
+		return "unsupported type"
+	case ModelErrorMissingPrimaryKey:
+		return "missing primary key"
+	case ModelErrorUnsupportedField:
+		return "unsupported field"
+	case ModelErrorUnknownTag:
+		return "unknown tag"
+	case ModelErrorInvalidTag:
+		return "invalid tag"
+	case ModelErrorUnknownForeignKey:
+		return "unknown foreign key"
+	case ModelErrorDuplicateConstraint:
+		return "duplicate constraint"
+	case ModelErrorInvalidDefault:
+		return "invalid default"
+	case ModelErrorDuplicateModelId:
+		return "duplicate model id"
+	case ModelErrorAmbiguousModel:
+		return "ambiguous model"
+	default:
+		return fmt.Sprintf("ModelErrorKind(%d)", int(k))
+	}
+}
+
+// ModelError describes a problem with the definition of a model.
+type ModelError struct {
+	// Model is the name of the model's type.
+	Model string
+
+	// Field is the name of the field with the problem, it is empty if the problem is with the
+	// model itself.
+	Field string
+
+	Kind    ModelErrorKind
+	Message string
+}
+
+func (e *ModelError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("model %s: %s: %s", e.Model, e.Kind, e.Message)
+	}
+
+	return fmt.Sprintf("model %s field [%s]: %s: %s", e.Model, e.Field, e.Kind, e.Message)
+}
+
+// ModelErrors is returned when a model has one or more problems with its definition.
+type ModelErrors []*ModelError
+
+func (e ModelErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// StaleVersionError is returned when the version of a record does not match its stored version.
+type StaleVersionError struct {
+	// Model is the name of the model's type.
+	Model string
+
+	// Key is the datum key of the record.
+	Key []byte
+
+	// Expected is the version of the provided record and Actual is the stored version.
+	Expected uint64
+	Actual   uint64
+}
+
+func (e *StaleVersionError) Error() string {
+	return fmt.Sprintf("%s: %s record with key [%s] is at version %d, not %d",
+		ErrStaleVersion, e.Model, Key(e.Key), e.Actual, e.Expected)
+}
+
+// Is allows the error to be matched against ErrStaleVersion.
+func (e *StaleVersionError) Is(target error) bool {
+	return target == ErrStaleVersion
+}
+
+// ValidationError describes a field of a record that does not satisfy one of the validation tags
+// of the field.
+type ValidationError struct {
+	// Model is the name of the model's type.
+	Model string
+
+	// Field is the name of the field that is not valid.
+	Field string
+
+	// Rule is the validation tag that was not satisfied, like min:1.
+	Rule    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s field [%s] %s", e.Model, e.Field, e.Message)
+}
+
+// ValidationErrors is returned when a record is inserted or updated with one or more fields that
+// do not satisfy their validation tags.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// CheckViolation is returned when a record that is being inserted or updated violates a check
+// constraint of its model.
+type CheckViolation struct {
+	// Model is the name of the model's type.
+	Model string
+
+	// Constraint is the name of the check constraint, it is Check when the violation comes from the
+	// Check method of the model.
+	Constraint string
+
+	// Err is the error returned by the Check method of the model, if there was one.
+	Err error
+}
+
+func (e *CheckViolation) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s violates check constraint [%s]: %v", e.Model, e.Constraint, e.Err)
+	}
+
+	return fmt.Sprintf("%s violates check constraint [%s]", e.Model, e.Constraint)
+}
+
+func (e *CheckViolation) Unwrap() error {
+	return e.Err
+}
+
+// UniqueViolation is returned when a record is inserted or updated with values that another record
+// already has for a unique constraint.
+type UniqueViolation struct {
+	// Model is the name of the model's type.
+	Model string
+
+	// Constraint is the name of the unique constraint.
+	Constraint string
+
+	// Values are the values of the constraint's fields, in the order of the fields.
+	Values []interface{}
+}
+
+func (e *UniqueViolation) Error() string {
+	return fmt.Sprintf("%s unique constraint [%s] already has a record with (%s)",
+		e.Model, e.Constraint, formatKeyValues(e.Values))
+}
+
+// PrimaryKeyViolation is returned when a record is inserted with a primary key that another record
+// already has.
+type PrimaryKeyViolation struct {
+	// Model is the name of the model's type.
+	Model string
+
+	// Values are the values of the primary key fields, in the order of the fields.
+	Values []interface{}
+}
+
+func (e *PrimaryKeyViolation) Error() string {
+	return fmt.Sprintf("%s already has a record with primary key (%s)", e.Model, formatKeyValues(e.Values))
+}
+
+// ForeignKeyViolation is returned when a record is inserted or updated with a foreign key that does
+// not reference an existing record.
+type ForeignKeyViolation struct {
+	// Model is the name of the model's type.
+	Model string
+
+	// ForeignKey is the name of the field that references the other model.
+	ForeignKey string
+
+	// References is the name of the model that is referenced.
+	References string
+
+	// Values are the values of the foreign key.
+	Values []interface{}
+}
+
+func (e *ForeignKeyViolation) Error() string {
+	return fmt.Sprintf("%s field [%s] references a %s record with primary key (%s) that does not exist",
+		e.Model, e.ForeignKey, e.References, formatKeyValues(e.Values))
+}
+
+// BatchDuplicate is returned when two records that are inserted together have the same primary
+// key or the same values for a unique constraint.
+type BatchDuplicate struct {
+	// Model is the name of the model's type.
+	Model string
+
+	// Constraint is the name of the unique constraint, it is empty if the records have the same
+	// primary key.
+	Constraint string
+
+	// Rows are the indexes of the two records within the batch.
+	Rows []int
+
+	// Values are the values the records have in common.
+	Values []interface{}
+}
+
+func (e *BatchDuplicate) Error() string {
+	if e.Constraint == "" {
+		return fmt.Sprintf("rows %d and %d of the %s batch have the same primary key (%s)",
+			e.Rows[0], e.Rows[1], e.Model, formatKeyValues(e.Values))
+	}
+
+	return fmt.Sprintf("rows %d and %d of the %s batch have the same values (%s) for unique constraint [%s]",
+		e.Rows[0], e.Rows[1], e.Model, formatKeyValues(e.Values), e.Constraint)
+}
+
+// RowError is the error for a single row of a slice of records that could not be inserted or
+// updated, or that could not be inserted by BulkInsert.
+type RowError struct {
+	// Row is the index of the row within the rows that were provided.
+	Row int
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// BulkInsertErrors is returned by BulkInsert when one or more rows could not be inserted, the
+// errors are in the order of the rows. Every other row was inserted.
+type BulkInsertErrors []*RowError
+
+func (e BulkInsertErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d row(s) could not be inserted: %s", len(e), strings.Join(messages, "; "))
+}
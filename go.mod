@@ -1,9 +1,9 @@
 module github.com/elliotcourant/mellivora
 
-go 1.12
+go 1.13
 
 require (
-	github.com/ahmetb/go-linq/v3 v3.1.0
+	github.com/dgraph-io/badger v1.6.0
 	github.com/elliotcourant/buffers v0.0.0-20191201042100-3d9daf6f332b
 	github.com/elliotcourant/meles v0.1.0
 	github.com/elliotcourant/timber v0.0.0-20190831033938-85b1f62dde82
@@ -0,0 +1,146 @@
+package mellivora
+
+import (
+	"reflect"
+)
+
+type (
+	// BeforeInserter is implemented by models that need to validate or change a record before it
+	// is inserted. Returning an error aborts the insert.
+	BeforeInserter interface {
+		BeforeInsert(txn *Transaction) error
+	}
+
+	// AfterInserter is implemented by models that are notified once a record has been inserted.
+	AfterInserter interface {
+		AfterInsert(txn *Transaction) error
+	}
+
+	// BeforeUpdater is implemented by models that need to validate or change a record before it
+	// is updated. Returning an error aborts the update.
+	BeforeUpdater interface {
+		BeforeUpdate(txn *Transaction) error
+	}
+
+	// AfterUpdater is implemented by models that are notified once a record has been updated.
+	AfterUpdater interface {
+		AfterUpdate(txn *Transaction) error
+	}
+
+	// BeforeDeleter is implemented by models that need to validate a record before it is deleted.
+	// Returning an error aborts the delete.
+	BeforeDeleter interface {
+		BeforeDelete(txn *Transaction) error
+	}
+
+	// AfterDeleter is implemented by models that are notified once a record has been deleted.
+	AfterDeleter interface {
+		AfterDelete(txn *Transaction) error
+	}
+
+	// AfterSelecter is implemented by models that need to derive fields of a record once it has
+	// been read by a query.
+	AfterSelecter interface {
+		AfterSelect(txn *Transaction) error
+	}
+)
+
+type hook int
+
+const (
+	hookBeforeInsert hook = iota
+	hookAfterInsert
+	hookBeforeUpdate
+	hookAfterUpdate
+	hookBeforeDelete
+	hookAfterDelete
+	hookAfterSelect
+)
+
+// getRecords returns the provided model, which is a single record or a slice or array of
+// records, along with each of its records. If the model cannot be addressed, like when it is
+// passed by value, then a copy is returned. This way hooks with pointer receivers can be called
+// and any changes they make are seen when the records are written.
+func getRecords(model interface{}) (reflect.Value, []reflect.Value) {
+	value := reflect.ValueOf(model)
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	if !value.CanAddr() {
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(value)
+		value = copied
+	}
+
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		records := make([]reflect.Value, value.Len())
+		for i := range records {
+			record := value.Index(i)
+			for record.Kind() == reflect.Ptr {
+				record = record.Elem()
+			}
+			records[i] = record
+		}
+
+		return value, records
+	default:
+		return value, []reflect.Value{value}
+	}
+}
+
+// rowError wraps the error of a record in a RowError with the index of the record when the model
+// is a slice or an array of records, so that the record that failed can be found.
+func rowError(value reflect.Value, row int, err error) error {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		return &RowError{Row: row, Err: err}
+	default:
+		return err
+	}
+}
+
+// runHooks calls the hook on every record that implements it. The first error returned by a hook
+// stops any of the remaining hooks from being called.
+func (txn *Transaction) runHooks(hook hook, records []reflect.Value) error {
+	for _, record := range records {
+		var err error
+		switch model := record.Addr().Interface(); hook {
+		case hookBeforeInsert:
+			if h, ok := model.(BeforeInserter); ok {
+				err = h.BeforeInsert(txn)
+			}
+		case hookAfterInsert:
+			if h, ok := model.(AfterInserter); ok {
+				err = h.AfterInsert(txn)
+			}
+		case hookBeforeUpdate:
+			if h, ok := model.(BeforeUpdater); ok {
+				err = h.BeforeUpdate(txn)
+			}
+		case hookAfterUpdate:
+			if h, ok := model.(AfterUpdater); ok {
+				err = h.AfterUpdate(txn)
+			}
+		case hookBeforeDelete:
+			if h, ok := model.(BeforeDeleter); ok {
+				err = h.BeforeDelete(txn)
+			}
+		case hookAfterDelete:
+			if h, ok := model.(AfterDeleter); ok {
+				err = h.AfterDelete(txn)
+			}
+		case hookAfterSelect:
+			if h, ok := model.(AfterSelecter); ok {
+				err = h.AfterSelect(txn)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
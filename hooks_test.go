@@ -0,0 +1,137 @@
+package mellivora
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+type testHookUser struct {
+	UserId uint64 `m:"pk"`
+	Email  string `m:"uq"`
+	Domain string
+
+	// calls records the hooks that have been called on the record.
+	calls []string `m:"-"`
+}
+
+func (u *testHookUser) BeforeInsert(txn *Transaction) error {
+	u.calls = append(u.calls, "BeforeInsert")
+	if !strings.Contains(u.Email, "@") {
+		return fmt.Errorf("invalid email [%s]", u.Email)
+	}
+
+	u.Email = strings.ToLower(u.Email)
+	u.Domain = u.Email[strings.Index(u.Email, "@")+1:]
+	return nil
+}
+
+func (u *testHookUser) AfterInsert(txn *Transaction) error {
+	u.calls = append(u.calls, "AfterInsert")
+	return nil
+}
+
+func (u *testHookUser) BeforeUpdate(txn *Transaction) error {
+	u.calls = append(u.calls, "BeforeUpdate")
+	return nil
+}
+
+func (u *testHookUser) AfterUpdate(txn *Transaction) error {
+	u.calls = append(u.calls, "AfterUpdate")
+	return nil
+}
+
+func (u *testHookUser) BeforeDelete(txn *Transaction) error {
+	u.calls = append(u.calls, "BeforeDelete")
+	if u.UserId == 1 {
+		return fmt.Errorf("user %d cannot be deleted", u.UserId)
+	}
+
+	return nil
+}
+
+func (u *testHookUser) AfterDelete(txn *Transaction) error {
+	u.calls = append(u.calls, "AfterDelete")
+	return nil
+}
+
+func (u *testHookUser) AfterSelect(txn *Transaction) error {
+	u.calls = append(u.calls, "AfterSelect")
+	return nil
+}
+
+func TestTransaction_Hooks(t *testing.T) {
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	t.Run("insert", func(t *testing.T) {
+		users := []testHookUser{
+			{UserId: 1, Email: "One@Example.com"},
+			{UserId: 2, Email: "two@example.com"},
+		}
+		err := txn.Insert(users)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"BeforeInsert", "AfterInsert"}, users[0].calls)
+		assert.Equal(t, "one@example.com", users[0].Email)
+
+		// Hooks are called on a copy of records that are inserted by value, the changes are
+		// still stored.
+		err = txn.Insert(testHookUser{UserId: 3, Email: "Three@Example.com"})
+		assert.NoError(t, err)
+
+		result := testHookUser{}
+		err = txn.Model(result).Where(Ex{"UserId": 3}).Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, "three@example.com", result.Email)
+		assert.Equal(t, "example.com", result.Domain)
+		assert.Equal(t, []string{"AfterSelect"}, result.calls)
+	})
+
+	t.Run("before insert aborts", func(t *testing.T) {
+		users := []testHookUser{
+			{UserId: 4, Email: "four@example.com"},
+			{UserId: 5, Email: "five"},
+		}
+		err := txn.Insert(users)
+		assert.EqualError(t, err, "invalid email [five]")
+		assert.Equal(t, []string{"BeforeInsert"}, users[0].calls, "after hooks should not be called")
+
+		results := make([]testHookUser, 0)
+		err = txn.Model(results).Where(Ex{"UserId": 4}).Select(&results)
+		assert.NoError(t, err)
+		assert.Empty(t, results, "no records should be inserted")
+	})
+
+	t.Run("update", func(t *testing.T) {
+		user := &testHookUser{UserId: 2, Email: "two@example.com", Domain: "example.com"}
+		err := txn.Update(user)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"BeforeUpdate", "AfterUpdate"}, user.calls)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		user := &testHookUser{UserId: 1}
+		err := txn.Delete(user)
+		assert.EqualError(t, err, "user 1 cannot be deleted")
+		assert.Equal(t, []string{"BeforeDelete"}, user.calls)
+
+		user = &testHookUser{UserId: 2}
+		err = txn.Delete(user)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"BeforeDelete", "AfterDelete"}, user.calls)
+	})
+
+	t.Run("select", func(t *testing.T) {
+		results := make([]testHookUser, 0)
+		err := txn.Model(results).Select(&results)
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+		for _, result := range results {
+			assert.Equal(t, []string{"AfterSelect"}, result.calls)
+		}
+	})
+}
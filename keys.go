@@ -0,0 +1,192 @@
+package mellivora
+
+import (
+	"fmt"
+	"github.com/elliotcourant/buffers"
+	"reflect"
+	"strings"
+)
+
+// Key is a key written to the store. Formatting it with %s or %v renders it the same way as
+// DecodeKey, which makes it useful for logging keys.
+type Key []byte
+
+func (k Key) String() string {
+	return formatKey(k)
+}
+
+// DecodeKey renders a key written to the store in a human readable form, like /datum/DataNode/1
+// or /unique/DataNode/uq_address_port/"127.0.0.1",5432. Index keys are rendered with the indexed
+// values followed by the primary key, like /index/DataNode/ix_region/"us"/1. The model of the key
+// must have been used or registered by this process.
+func DecodeKey(key []byte) (decoded string, err error) {
+	// The reader panics when a key is shorter than expected.
+	defer func() {
+		if r := recover(); r != nil {
+			decoded, err = "", fmt.Errorf("key [%x] is not valid: %v", key, r)
+		}
+	}()
+
+	reader := buffers.NewBytesReader(key)
+	prefix := reader.NextByte()
+	var prefixName string
+	switch prefix {
+	case datumKeyPrefix:
+		prefixName = "datum"
+	case uniqueKeyPrefix:
+		prefixName = "unique"
+	case indexKeyPrefix:
+		prefixName = "index"
+	default:
+		return "", fmt.Errorf("key [%x] does not have a known prefix", key)
+	}
+
+	modelId := reader.NextUint32()
+	model, found := models.getModelById(modelId)
+	switch {
+	case found > 1:
+		return "", fmt.Errorf("key [%x] belongs to a model with Id %d that is shared by %d types", key, modelId, found)
+	case model == nil:
+		return "", fmt.Errorf("key [%x] belongs to a model with Id %d that is not known", key, modelId)
+	}
+
+	parts := []string{"", prefixName, model.Name()}
+	var fields []Field
+	switch prefix {
+	case uniqueKeyPrefix:
+		uniqueConstraint := model.UniqueConstraints().GetById(reader.NextUint32())
+		if uniqueConstraint == nil {
+			return "", fmt.Errorf("key [%x] is not a unique constraint of %s", key, model.Name())
+		}
+		parts = append(parts, uniqueConstraint.Name())
+		fields = uniqueConstraint.Fields().GetAll()
+	case indexKeyPrefix:
+		index := model.Indexes().GetById(reader.NextUint32())
+		if index == nil {
+			return "", fmt.Errorf("key [%x] is not an index of %s", key, model.Name())
+		}
+		parts = append(parts, index.Name())
+
+		values, err := readKeyValues(reader, index.Fields().GetAll())
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, formatKeyValues(values))
+		fields = model.PrimaryKey().GetAll()
+	default:
+		fields = model.PrimaryKey().GetAll()
+	}
+
+	values, err := readKeyValues(reader, fields)
+	if err != nil {
+		return "", err
+	}
+	parts = append(parts, formatKeyValues(values))
+
+	return strings.Join(parts, "/"), nil
+}
+
+// formatKey returns the decoded form of the key, or the key as hex if it cannot be decoded.
+func formatKey(key []byte) string {
+	if decoded, err := DecodeKey(key); err == nil {
+		return decoded
+	}
+
+	return fmt.Sprintf("%x", key)
+}
+
+// readKeyValues reads the key encoding of each of the fields from the reader and returns their
+// values.
+func readKeyValues(reader buffers.BytesReader, fields []Field) ([]interface{}, error) {
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		codec, err := getFieldCodec(field)
+		if err != nil {
+			return nil, err
+		}
+
+		target := reflect.New(field.Reflection().Type).Elem()
+		if err := codec.ReadKey(reader, target); err != nil {
+			return nil, err
+		}
+		values[i] = target.Interface()
+	}
+
+	return values, nil
+}
+
+// primaryKeyValues returns the values of the primary key fields of the record.
+func primaryKeyValues(model Model, record reflect.Value) []interface{} {
+	primaryKey := model.PrimaryKey().GetAll()
+	values := make([]interface{}, len(primaryKey))
+	for i, field := range primaryKey {
+		values[i] = record.FieldByIndex(field.Reflection().Index).Interface()
+	}
+
+	return values
+}
+
+// formatKeyValues renders the values of a key in a human readable form. Strings are quoted and
+// nulls are written as NULL.
+func formatKeyValues(values []interface{}) string {
+	items := make([]string, len(values))
+	for i, item := range values {
+		value := reflect.ValueOf(item)
+		for value.Kind() == reflect.Ptr && !value.IsNil() {
+			value = value.Elem()
+		}
+
+		switch {
+		case isNullValue(value):
+			items[i] = "NULL"
+		case value.Kind() == reflect.String:
+			items[i] = fmt.Sprintf("%q", value.String())
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Uint8:
+			items[i] = fmt.Sprintf("%q", value.Bytes())
+		default:
+			items[i] = fmt.Sprint(value.Interface())
+		}
+	}
+
+	return strings.Join(items, ",")
+}
+
+// keyViolation returns the error for a datum or unique key of the model that already exists.
+func keyViolation(model Model, key []byte) error {
+	reader := buffers.NewBytesReader(key)
+	prefix := reader.NextByte()
+	if modelId := reader.NextUint32(); modelId != model.ModelId() {
+		return fmt.Errorf("key [%s] does not belong to %s", Key(key), model.Name())
+	}
+
+	switch prefix {
+	case datumKeyPrefix:
+		values, err := readKeyValues(reader, model.PrimaryKey().GetAll())
+		if err != nil {
+			return err
+		}
+
+		return &PrimaryKeyViolation{
+			Model:  model.Name(),
+			Values: values,
+		}
+	case uniqueKeyPrefix:
+		uniqueConstraint := model.UniqueConstraints().GetById(reader.NextUint32())
+		if uniqueConstraint == nil {
+			return fmt.Errorf("key [%s] is not a unique constraint of %s", Key(key), model.Name())
+		}
+
+		values, err := readKeyValues(reader, uniqueConstraint.Fields().GetAll())
+		if err != nil {
+			return err
+		}
+
+		return &UniqueViolation{
+			Model:      model.Name(),
+			Constraint: uniqueConstraint.Name(),
+			Values:     values,
+		}
+	default:
+		return fmt.Errorf("key [%s] of %s already exists", Key(key), model.Name())
+	}
+}
@@ -0,0 +1,109 @@
+package mellivora
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeKey(t *testing.T) {
+	type KeyNode struct {
+		KeyNodeId uint64 `m:"pk"`
+		Address   string `m:"uq:uq_address_port"`
+		Port      int32  `m:"uq:uq_address_port"`
+		Region    string `m:"index"`
+		Zone      *string
+	}
+
+	node := KeyNode{
+		KeyNodeId: 1,
+		Address:   "127.0.0.1",
+		Port:      5432,
+		Region:    "us",
+	}
+
+	info, err := getModelInfo(node)
+	assert.NoError(t, err)
+
+	keys, err := newDatumBuilder(info, reflect.ValueOf(node), false).Keys()
+	assert.NoError(t, err)
+
+	decoded := make([]string, 0, len(keys))
+	for key := range keys {
+		decodedKey, err := DecodeKey([]byte(key))
+		assert.NoError(t, err)
+		decoded = append(decoded, decodedKey)
+	}
+
+	assert.ElementsMatch(t, []string{
+		`/datum/KeyNode/1`,
+		`/unique/KeyNode/uq_address_port/"127.0.0.1",5432`,
+		`/index/KeyNode/ix_region/"us"/1`,
+	}, decoded)
+
+	t.Run("key", func(t *testing.T) {
+		datumKey := append(newDatumBuilder(info, reflect.ValueOf(node), false).DatumPrefix(), 0, 0, 0, 0, 0, 0, 0, 2)
+		assert.Equal(t, "key [/datum/KeyNode/2]", fmt.Sprintf("key [%s]", Key(datumKey)))
+	})
+
+	t.Run("datum reader", func(t *testing.T) {
+		uniqueKey := append(newDatumBuilder(info, reflect.ValueOf(node), false).UniquePrefix(info.UniqueConstraints().GetAll()[0]),
+			[]byte("127.0.0.1\x00\x01\x80\x00\x15\x38")...)
+		_, err := newDatumReader(info).Read(uniqueKey, nil)
+		assert.EqualError(t, err, `key [/unique/KeyNode/uq_address_port/"127.0.0.1",5432] is not a datum`)
+
+		type Other struct {
+			OtherId uint64 `m:"pk"`
+		}
+		otherInfo, err := getModelInfo(Other{})
+		assert.NoError(t, err)
+
+		otherKey := append(newDatumBuilder(otherInfo, reflect.ValueOf(Other{}), false).DatumPrefix(), 0, 0, 0, 0, 0, 0, 0, 1)
+		_, err = newDatumReader(info).Read(otherKey, nil)
+		assert.EqualError(t, err, "datum [/datum/Other/1] is not [KeyNode], it is Other")
+	})
+
+	t.Run("unknown prefix", func(t *testing.T) {
+		_, err := DecodeKey([]byte{9, 0, 0, 0, 1})
+		assert.EqualError(t, err, "key [0900000001] does not have a known prefix")
+		assert.Equal(t, "0900000001", Key{9, 0, 0, 0, 1}.String())
+	})
+
+	t.Run("unknown model", func(t *testing.T) {
+		_, err := DecodeKey([]byte{datumKeyPrefix, 0, 0, 0, 1})
+		assert.EqualError(t, err, "key [0100000001] belongs to a model with Id 1 that is not known")
+	})
+
+	t.Run("ambiguous model", func(t *testing.T) {
+		var datumKey []byte
+		{
+			type AmbiguousNode struct {
+				NodeId uint64 `m:"pk"`
+			}
+			info, err := getModelInfo(AmbiguousNode{})
+			assert.NoError(t, err)
+
+			datumKey = append(newDatumBuilder(info, reflect.ValueOf(AmbiguousNode{}), false).DatumPrefix(), 0, 0, 0, 0, 0, 0, 0, 1)
+		}
+		{
+			type AmbiguousNode struct {
+				NodeId uint64 `m:"pk"`
+			}
+			_, err := getModelInfo(AmbiguousNode{})
+			assert.NoError(t, err)
+		}
+
+		_, err := DecodeKey(datumKey)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "that is shared by 2 types")
+	})
+
+	t.Run("too short", func(t *testing.T) {
+		_, err := DecodeKey(newDatumBuilder(info, reflect.ValueOf(node), false).DatumPrefix())
+		assert.Error(t, err)
+
+		_, err = DecodeKey([]byte{datumKeyPrefix, 0})
+		assert.Error(t, err)
+	})
+}
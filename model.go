@@ -1,12 +1,15 @@
 package mellivora
 
 import (
+	"encoding"
 	"fmt"
-	"github.com/ahmetb/go-linq/v3"
 	"hash/fnv"
-	"math/rand"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
@@ -16,6 +19,8 @@ var (
 	_ FieldSet            = &fieldSet{}
 	_ UniqueConstraint    = &uniqueConstraint{}
 	_ UniqueConstraintSet = &uniqueConstraintSet{}
+	_ Index               = &index{}
+	_ IndexSet            = &indexSet{}
 )
 
 type (
@@ -26,9 +31,17 @@ type (
 		Fields() FieldSet
 		PrimaryKey() FieldSet
 		UniqueConstraints() UniqueConstraintSet
+		Indexes() IndexSet
 		Relations()
 	}
 
+	// ModelNamer is implemented by models that name themselves. The name is used instead of the
+	// package and name of the type to generate the Ids of the model, so records are still found if
+	// the type is renamed or moved. It must not change once records have been stored.
+	ModelNamer interface {
+		ModelName() string
+	}
+
 	Relation interface {
 		RelationId() uint32
 		Name() string
@@ -40,6 +53,7 @@ type (
 	Field interface {
 		FieldId() uint32
 		Name() string
+		ColumnName() string
 		IsPrimaryKey() bool
 		Reflection() reflect.StructField
 	}
@@ -61,6 +75,18 @@ type (
 		GetById(uniqueConstraintId uint32) UniqueConstraint
 		GetByName(uniqueConstraintName string) UniqueConstraint
 	}
+
+	Index interface {
+		IndexId() uint32
+		Name() string
+		Fields() FieldSet
+	}
+
+	IndexSet interface {
+		GetAll() []Index
+		GetById(indexId uint32) Index
+		GetByName(indexName string) Index
+	}
 )
 
 type relation struct {
@@ -110,33 +136,111 @@ func (u *uniqueConstraint) Fields() FieldSet {
 
 type uniqueConstraintSet struct {
 	constraints []UniqueConstraint
+	byId        map[uint32]UniqueConstraint
+	byName      map[string]UniqueConstraint
+}
+
+func newUniqueConstraintSet(constraints []UniqueConstraint) *uniqueConstraintSet {
+	set := &uniqueConstraintSet{
+		constraints: constraints,
+		byId:        make(map[uint32]UniqueConstraint, len(constraints)),
+		byName:      make(map[string]UniqueConstraint, len(constraints)),
+	}
+
+	for _, constraint := range constraints {
+		set.byId[constraint.UniqueConstraintId()] = constraint
+		set.byName[constraint.Name()] = constraint
+	}
+
+	return set
 }
 
 func (u *uniqueConstraintSet) GetAll() []UniqueConstraint {
 	return u.constraints
 }
 
+// GetById returns the unique constraint with the provided Id, or nil if there is not one.
 func (u *uniqueConstraintSet) GetById(uniqueConstraintId uint32) UniqueConstraint {
-	return linq.From(u.constraints).FirstWith(func(i interface{}) bool {
-		constraint, ok := i.(UniqueConstraint)
-		return ok && constraint.UniqueConstraintId() == uniqueConstraintId
-	}).(UniqueConstraint)
+	return u.byId[uniqueConstraintId]
 }
 
+// GetByName returns the unique constraint with the provided name, or nil if there is not one.
 func (u *uniqueConstraintSet) GetByName(uniqueConstraintName string) UniqueConstraint {
-	return linq.From(u.constraints).FirstWith(func(i interface{}) bool {
-		constraint, ok := i.(UniqueConstraint)
-		return ok && constraint.Name() == uniqueConstraintName
-	}).(UniqueConstraint)
+	return u.byName[uniqueConstraintName]
+}
+
+type index struct {
+	indexId uint32
+	name    string
+	fields  FieldSet
+}
+
+func (i *index) IndexId() uint32 {
+	return i.indexId
+}
+
+func (i *index) Name() string {
+	return i.name
+}
+
+func (i *index) Fields() FieldSet {
+	return i.fields
+}
+
+type indexSet struct {
+	indexes []Index
+	byId    map[uint32]Index
+	byName  map[string]Index
+}
+
+func newIndexSet(indexes []Index) *indexSet {
+	set := &indexSet{
+		indexes: indexes,
+		byId:    make(map[uint32]Index, len(indexes)),
+		byName:  make(map[string]Index, len(indexes)),
+	}
+
+	for _, idx := range indexes {
+		set.byId[idx.IndexId()] = idx
+		set.byName[idx.Name()] = idx
+	}
+
+	return set
+}
+
+func (i *indexSet) GetAll() []Index {
+	return i.indexes
+}
+
+// GetById returns the index with the provided Id, or nil if there is not one.
+func (i *indexSet) GetById(indexId uint32) Index {
+	return i.byId[indexId]
+}
+
+// GetByName returns the index with the provided name, or nil if there is not one.
+func (i *indexSet) GetByName(indexName string) Index {
+	return i.byName[indexName]
 }
 
 type modelInfo struct {
 	modelId           uint32
 	name              string
+	identity          string
 	typ               reflect.Type
 	fields            FieldSet
 	primaryKey        FieldSet
 	uniqueConstraints UniqueConstraintSet
+	indexes           IndexSet
+
+	// createdAt, updatedAt and deletedAt are the fields that are set automatically when a record
+	// is inserted, updated or deleted. They are nil if the model does not have them.
+	createdAt Field
+	updatedAt Field
+	deletedAt Field
+
+	// version is the integer field that is incremented every time a record is updated, it is nil
+	// if the model does not have one.
+	version Field
 }
 
 func (m *modelInfo) Relations() {
@@ -151,6 +255,10 @@ func (m *modelInfo) UniqueConstraints() UniqueConstraintSet {
 	return m.uniqueConstraints
 }
 
+func (m *modelInfo) Indexes() IndexSet {
+	return m.indexes
+}
+
 func (m *modelInfo) ModelId() uint32 {
 	return m.modelId
 }
@@ -172,8 +280,23 @@ type modelField struct {
 
 	fieldId      uint32
 	name         string
+	columnName   string
 	isPrimaryKey bool
+	isRelation   bool
+	isReadOnly   bool
 	reflection   reflect.StructField
+	codec        columnCodec
+
+	// defaultValue is set on the field when a record is inserted and the field has its zero value.
+	// It is not valid if the field does not have a default.
+	defaultValue reflect.Value
+
+	// rules are checked against the field before a record is inserted or updated.
+	rules []*validationRule
+
+	// foreignKey is the field that holds the primary key of the related record when this field is
+	// a relation.
+	foreignKey Field
 }
 
 func (m *modelField) IsPrimaryKey() bool {
@@ -192,31 +315,59 @@ func (m *modelField) Name() string {
 	return m.name
 }
 
+// ColumnName is the name that the field is stored by. It is the name of the struct field unless a
+// name is provided in the field's tag, this way the field can be renamed without changing the
+// Ids that are derived from it.
+func (m *modelField) ColumnName() string {
+	return m.columnName
+}
+
 type fieldSet struct {
 	model  Model
 	fields []Field
+	byId   map[uint32]Field
+	byName map[string]Field
+}
+
+func newFieldSet(model Model, fields []Field) *fieldSet {
+	set := &fieldSet{
+		model:  model,
+		fields: fields,
+		byId:   make(map[uint32]Field, len(fields)),
+		byName: make(map[string]Field, len(fields)),
+	}
+
+	for _, field := range fields {
+		set.byId[field.FieldId()] = field
+		set.byName[field.Name()] = field
+	}
+
+	return set
 }
 
+// GetById returns the field with the provided Id, or nil if there is not one.
 func (f *fieldSet) GetById(fieldId uint32) Field {
-	return linq.From(f.fields).FirstWith(func(i interface{}) bool {
-		field, ok := i.(Field)
-		return ok && field.FieldId() == fieldId
-	}).(Field)
+	return f.byId[fieldId]
 }
 
+// GetByName returns the field with the provided name, or nil if there is not one.
 func (f *fieldSet) GetByName(fieldName string) Field {
-	return linq.From(f.fields).FirstWith(func(i interface{}) bool {
-		field, ok := i.(Field)
-		return ok && field.Name() == fieldName
-	}).(Field)
+	return f.byName[fieldName]
 }
 
 func (f *fieldSet) GetAll() []Field {
 	return f.fields
 }
 
-func getBaseTypeOf(model interface{}) reflect.Type {
+func getBaseTypeOf(model interface{}) (reflect.Type, error) {
 	typ := reflect.TypeOf(model)
+	if typ == nil {
+		return nil, &ModelError{
+			Model:   "nil",
+			Kind:    ModelErrorUnsupportedType,
+			Message: "a model cannot be nil",
+		}
+	}
 
 	// We need to make sure we are working with the base type.
 	for {
@@ -224,124 +375,600 @@ func getBaseTypeOf(model interface{}) reflect.Type {
 		case reflect.Ptr, reflect.Array, reflect.Slice:
 			typ = typ.Elem()
 		case reflect.Struct:
-			return typ
+			return typ, nil
 		default:
-			panic(fmt.Sprintf("%T/%s is not a supported type/kind", model, typ.Kind()))
+			return nil, &ModelError{
+				Model:   fmt.Sprintf("%T", model),
+				Kind:    ModelErrorUnsupportedType,
+				Message: fmt.Sprintf("%s is not a supported kind, models must be structs", typ.Kind()),
+			}
 		}
 	}
 }
 
-func getModelInfo(model interface{}) Model {
-	typ := getBaseTypeOf(model)
+// modelRegistry caches the metadata for each model type so that the reflection and tag parsing
+// for a model only happens the first time it is used. Models that are not valid are cached with
+// their error. Valid models can also be found by their Id so that keys can be decoded, more than
+// one type can have the same Id when types declared in different functions share a name.
+type modelRegistry struct {
+	lock   sync.RWMutex
+	models map[reflect.Type]modelRegistryEntry
+	byId   map[uint32][]Model
+}
 
-	modelId := fnv.New32()
+type modelRegistryEntry struct {
+	model Model
+	err   error
+}
+
+var models = &modelRegistry{
+	models: map[reflect.Type]modelRegistryEntry{},
+	byId:   map[uint32][]Model{},
+}
+
+func (r *modelRegistry) getModel(typ reflect.Type) (Model, error) {
+	r.lock.RLock()
+	entry, ok := r.models[typ]
+	r.lock.RUnlock()
+	if ok {
+		return entry.model, entry.err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// Another goroutine might have built the model while we were waiting for the lock.
+	if entry, ok = r.models[typ]; ok {
+		return entry.model, entry.err
+	}
+
+	entry.model, entry.err = newModelInfo(typ)
+	r.models[typ] = entry
+	if entry.err == nil {
+		r.byId[entry.model.ModelId()] = append(r.byId[entry.model.ModelId()], entry.model)
+	}
+
+	return entry.model, entry.err
+}
+
+// getModelById returns the model with the provided Id if it has been used. If the Id is unknown,
+// or more than one type has the Id, then nil is returned along with the number of types found.
+func (r *modelRegistry) getModelById(modelId uint32) (Model, int) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	found := r.byId[modelId]
+	if len(found) != 1 {
+		return nil, len(found)
+	}
+
+	return found[0], 1
+}
+
+// getModelInfo returns the metadata for the model's type. If the model is not valid then an error
+// is returned describing every problem with it.
+func getModelInfo(model interface{}) (Model, error) {
+	typ, err := getBaseTypeOf(model)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.getModel(typ)
+}
+
+func newModelInfo(typ reflect.Type) (Model, error) {
+	errs := make(ModelErrors, 0)
+	addError := func(fieldName string, kind ModelErrorKind, message string, args ...interface{}) {
+		errs = append(errs, &ModelError{
+			Model:   typ.Name(),
+			Field:   fieldName,
+			Kind:    kind,
+			Message: fmt.Sprintf(message, args...),
+		})
+	}
+
+	// The model path is hashed into the Ids of the model and of its fields, constraints and
+	// indexes. A model can name itself so that those Ids do not change when the type is renamed or
+	// moved to another package.
 	modelPath := fmt.Sprint(typ.PkgPath(), typ.Name())
+	if name := getModelName(typ, addError); len(name) > 0 {
+		modelPath = name
+	}
+
+	modelId := fnv.New32()
 	_, _ = modelId.Write([]byte(modelPath))
 
 	mInfo := &modelInfo{
-		modelId: modelId.Sum32(),
-		name:    typ.Name(),
-		typ:     typ,
+		modelId:  modelId.Sum32(),
+		name:     typ.Name(),
+		identity: modelPath,
+		typ:      typ,
 	}
 
-	fields := &fieldSet{
-		model:  mInfo,
-		fields: make([]Field, 0),
-	}
+	fields := make([]Field, 0)
+	primaryKey := make([]Field, 0)
+
+	// Unique constraints are also kept in the order they are first seen. Unnamed constraints are
+	// keyed by their field until their name is generated so they cannot be merged with others.
+	uniqueNames := make([]string, 0)
+	uniqueConstraintMap := map[string][]Field{}
+
+	// Indexes are kept in the order they are first seen on the model so that the order of the
+	// index set is stable.
+	indexNames := make([]string, 0)
+	indexMap := map[string][]Field{}
+
+	// Foreign keys are checked once all of the fields are known.
+	foreignKeys := map[*modelField]string{}
 
-	primaryKey := &fieldSet{
-		model:  mInfo,
-		fields: make([]Field, 0),
+	structFields, ambiguousFields := getStructFields(typ)
+	for _, name := range ambiguousFields {
+		addError(name, ModelErrorUnsupportedField, "the field is declared by more than one embedded struct")
 	}
 
-	uniqueConstraintMap := map[string][]Field{}
+	for _, reflection := range structFields {
+		tag := reflection.Tag.Get("m")
+
+		flags := getFlags(tag)
 
-	numFields := typ.NumField()
-	for i := 0; i < numFields; i++ {
-		reflection := typ.Field(i)
+		columnName := reflection.Name
+		if name, ok := flags["name"]; ok {
+			if len(name) == 0 {
+				addError(reflection.Name, ModelErrorInvalidTag, "name must not be empty")
+			} else {
+				columnName = name
+			}
+		}
 
 		fieldId := fnv.New32()
 		_, _ = fieldId.Write([]byte(modelPath))
-		_, _ = fieldId.Write([]byte(reflection.Name))
+		_, _ = fieldId.Write([]byte(columnName))
 
 		field := &modelField{
 			model:        mInfo,
 			fieldId:      fieldId.Sum32(),
 			name:         reflection.Name,
+			columnName:   columnName,
 			isPrimaryKey: false,
 			reflection:   reflection,
+			codec:        getColumnCodec(reflection.Type),
 		}
 
-		flags := getFlags(reflection.Tag.Get("m"))
+		// The keys are sorted so that errors and constraints are always in the same order.
+		keys := make([]string, 0, len(flags))
+		for key := range flags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
 
-		for key, value := range flags {
+		for _, key := range keys {
+			value := flags[key]
 			switch key {
+			case "":
+				// An empty tag, or an empty item in a tag, has nothing to apply.
 			case "pk":
 				field.isPrimaryKey = true
+			case "name":
+				// The column name is needed before any other tags are applied.
+			case "readonly":
+				field.isReadOnly = true
+			case "created_at", "updated_at", "deleted_at":
+				if reflection.Type != timeType && reflection.Type != reflect.PtrTo(timeType) {
+					addError(field.Name(), ModelErrorInvalidTag, "%s must be a time.Time or *time.Time", key)
+					continue
+				}
+
+				timestampField := &mInfo.createdAt
+				switch key {
+				case "updated_at":
+					timestampField = &mInfo.updatedAt
+				case "deleted_at":
+					timestampField = &mInfo.deletedAt
+				}
+
+				if *timestampField != nil {
+					addError(field.Name(), ModelErrorInvalidTag, "%s is already used by [%s]", key, (*timestampField).Name())
+					continue
+				}
+				*timestampField = field
+			case "version":
+				switch reflection.Type.Kind() {
+				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+					reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				default:
+					addError(field.Name(), ModelErrorInvalidTag, "version must be an integer")
+					continue
+				}
+
+				if mInfo.version != nil {
+					addError(field.Name(), ModelErrorInvalidTag, "version is already used by [%s]", mInfo.version.Name())
+					continue
+				}
+				mInfo.version = field
+			case "min", "max", "notempty", "maxlen", "regex", "oneof":
+				if name := tagAfterRegex(value); key == "regex" && len(name) > 0 {
+					addError(field.Name(), ModelErrorInvalidTag, "regex must be the last tag, [%s] follows it", name)
+					continue
+				}
+
+				rule, err := newValidationRule(key, value, reflection.Type)
+				if err != nil {
+					addError(field.Name(), ModelErrorInvalidTag, "%v", err)
+					continue
+				}
+				field.rules = append(field.rules, rule)
+			case "default":
+				defaultValue, err := parseTagValue(value, reflection.Type)
+				if err != nil {
+					addError(field.Name(), ModelErrorInvalidDefault, "%v", err)
+					continue
+				}
+				field.defaultValue = defaultValue
+			case "serial":
+				// Serial fields are generated by the store, there is nothing to apply to the field.
 			case "fk":
+				foreignKeys[field] = value
+				field.isRelation = true
+			case "json":
+				field.codec = &jsonCodec{}
+
+			case "unique", "uq":
 				if len(value) == 0 {
-					panic("must specify fk field")
+					value = fmt.Sprintf("`%s", field.Name())
 				}
+				constraintFields, ok := uniqueConstraintMap[value]
+				if !ok {
+					uniqueNames = append(uniqueNames, value)
+				}
+				uniqueConstraintMap[value] = append(constraintFields, field)
 
-			case "unique", "uq":
+			case "index", "idx":
+				// An index without a name only covers the field it is declared on.
 				if len(value) == 0 {
-					value = fmt.Sprintf("`uq_%d", rand.Uint32())
+					value = fmt.Sprintf("ix_%s", strings.ToLower(field.ColumnName()))
 				}
-				constraintFieldSet, ok := uniqueConstraintMap[value]
+				indexFields, ok := indexMap[value]
 				if !ok {
-					constraintFieldSet = make([]Field, 0)
+					indexNames = append(indexNames, value)
 				}
-				constraintFieldSet = append(constraintFieldSet, field)
-				uniqueConstraintMap[value] = constraintFieldSet
+				indexMap[value] = append(indexFields, field)
+
+			default:
+				addError(field.Name(), ModelErrorUnknownTag, "[%s] is not a known tag", key)
 			}
 		}
 
+		if field.codec == nil && !field.isRelation {
+			addError(field.Name(), ModelErrorUnsupportedField, "%s cannot be stored", reflection.Type)
+		}
+
 		if field.isPrimaryKey {
-			primaryKey.fields = append(primaryKey.fields, field)
+			if mInfo.version == field {
+				addError(field.Name(), ModelErrorInvalidTag, "version cannot be part of the primary key")
+			}
+			primaryKey = append(primaryKey, field)
 		}
 
-		fields.fields = append(fields.fields, field)
+		fields = append(fields, field)
 	}
 
-	uniqueConstraints := &uniqueConstraintSet{
-		constraints: make([]UniqueConstraint, 0),
+	if len(primaryKey) == 0 {
+		addError("", ModelErrorMissingPrimaryKey, "at least one field must be tagged with pk")
 	}
 
-	for uniqueName, uniqueFields := range uniqueConstraintMap {
-		if uniqueName[0] == '`' {
-			names := make([]string, len(uniqueFields))
-			for i, field := range uniqueFields {
-				names[i] = strings.ToLower(field.Name())
+	for _, field := range fields {
+		target, ok := foreignKeys[field.(*modelField)]
+		if !ok {
+			continue
+		}
+
+		if len(target) == 0 {
+			addError(field.Name(), ModelErrorUnknownForeignKey, "fk must name the field that references %s", field.Reflection().Type)
+			continue
+		}
+
+		for _, other := range fields {
+			if other.Name() == target && other != field {
+				field.(*modelField).foreignKey = other
 			}
-			// TODO (elliotcourant) make sure that the autogenerated unique name doesnt conflict.
-			uniqueName = fmt.Sprintf("uq_%s", strings.Join(names, "_"))
 		}
 
+		if field.(*modelField).foreignKey == nil {
+			addError(field.Name(), ModelErrorUnknownForeignKey, "[%s] is not a field of %s", target, typ.Name())
+		}
+	}
+
+	uniqueConstraints := make([]UniqueConstraint, 0, len(uniqueNames))
+	uniqueNamesSeen := map[string]string{}
+	uniqueIdsSeen := map[uint32]string{}
+
+	for _, uniqueKey := range uniqueNames {
+		uniqueFields := uniqueConstraintMap[uniqueKey]
+		uniqueName := uniqueKey
+		if uniqueName[0] == '`' {
+			uniqueName = fmt.Sprintf("uq_%s", strings.ToLower(uniqueFields[0].ColumnName()))
+		}
+
+		// Generated names could be the same as a name provided in a tag, or as each other if
+		// fields only differ by case. Since the name determines the keys of the constraint two
+		// constraints with the same name would enforce each other's values.
+		if other, ok := uniqueNamesSeen[uniqueName]; ok {
+			addError(uniqueFields[0].Name(), ModelErrorDuplicateConstraint,
+				"unique constraint name [%s] is used by fields [%s] and [%s]",
+				uniqueName, other, getFieldNames(uniqueFields),
+			)
+			continue
+		}
+		uniqueNamesSeen[uniqueName] = getFieldNames(uniqueFields)
+
 		uniqueId := fnv.New32()
 		_, _ = uniqueId.Write([]byte(modelPath))
 		_, _ = uniqueId.Write([]byte(uniqueName))
 
-		uniqueConstraints.constraints = append(uniqueConstraints.constraints, &uniqueConstraint{
+		if other, ok := uniqueIdsSeen[uniqueId.Sum32()]; ok {
+			addError(uniqueFields[0].Name(), ModelErrorDuplicateConstraint,
+				"unique constraints [%s] and [%s] have the same id, one must be renamed",
+				other, uniqueName,
+			)
+			continue
+		}
+		uniqueIdsSeen[uniqueId.Sum32()] = uniqueName
+
+		uniqueConstraints = append(uniqueConstraints, &uniqueConstraint{
 			uniqueConstraintId: uniqueId.Sum32(),
 			name:               uniqueName,
-			fields: &fieldSet{
-				model:  mInfo,
-				fields: uniqueFields,
-			},
+			fields:             newFieldSet(mInfo, uniqueFields),
 		})
 	}
 
-	mInfo.fields = fields
-	mInfo.primaryKey = primaryKey
-	mInfo.uniqueConstraints = uniqueConstraints
+	indexes := make([]Index, 0, len(indexNames))
+
+	for _, indexName := range indexNames {
+		if other, ok := uniqueNamesSeen[indexName]; ok {
+			addError(indexMap[indexName][0].Name(), ModelErrorDuplicateConstraint,
+				"index name [%s] is already used by the unique constraint on fields [%s]",
+				indexName, other,
+			)
+			continue
+		}
 
-	return mInfo
+		indexId := fnv.New32()
+		_, _ = indexId.Write([]byte(modelPath))
+		_, _ = indexId.Write([]byte(indexName))
+
+		indexes = append(indexes, &index{
+			indexId: indexId.Sum32(),
+			name:    indexName,
+			fields:  newFieldSet(mInfo, indexMap[indexName]),
+		})
+	}
+
+	mInfo.fields = newFieldSet(mInfo, fields)
+	mInfo.primaryKey = newFieldSet(mInfo, primaryKey)
+	mInfo.uniqueConstraints = newUniqueConstraintSet(uniqueConstraints)
+	mInfo.indexes = newIndexSet(indexes)
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return mInfo, nil
+}
+
+// getModelName returns the name the model declares for itself, either with the model tag on a
+// blank field or by implementing ModelNamer. An empty string is returned if it does not have one.
+func getModelName(typ reflect.Type, addError func(string, ModelErrorKind, string, ...interface{})) string {
+	name := ""
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Name != "_" {
+			continue
+		}
+
+		flags := getFlags(field.Tag.Get("m"))
+		keys := make([]string, 0, len(flags))
+		for key := range flags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			value := flags[key]
+			switch {
+			case key == "":
+			case key != "model":
+				addError(field.Name, ModelErrorUnknownTag, "[%s] cannot be used on a blank field", key)
+			case len(value) == 0:
+				addError(field.Name, ModelErrorInvalidTag, "model must not be empty")
+			case len(name) > 0 && name != value:
+				addError(field.Name, ModelErrorInvalidTag, "model [%s] conflicts with [%s]", value, name)
+			default:
+				name = value
+			}
+		}
+	}
+
+	if namer, ok := reflect.New(typ).Interface().(ModelNamer); ok {
+		switch modelName := namer.ModelName(); {
+		case len(modelName) == 0:
+			addError("", ModelErrorInvalidTag, "ModelName must not return an empty name")
+		case len(name) > 0 && name != modelName:
+			addError("", ModelErrorInvalidTag, "ModelName returns [%s] but the model tag is [%s]", modelName, name)
+		default:
+			name = modelName
+		}
+	}
+
+	return name
+}
+
+// getStructFields returns the fields of the struct that should be part of the model. Ignored
+// fields are not returned and the fields of embedded structs are flattened into the struct, the
+// index of each field is the path to it from the outer struct. Like Go, fields that are declared
+// closer to the outer struct hide embedded fields with the same name. The names of fields that
+// are declared more than once at the same depth are returned as ambiguous.
+func getStructFields(typ reflect.Type) (fields []reflect.StructField, ambiguous []string) {
+	type candidate struct {
+		field reflect.StructField
+		depth int
+	}
+
+	candidates := make([]candidate, 0, typ.NumField())
+	var collect func(typ reflect.Type, index []int, depth int)
+	collect = func(typ reflect.Type, index []int, depth int) {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			field.Index = append(append(make([]int, 0, len(index)+1), index...), i)
+
+			// Blank fields can only be used to name the model, they are never stored.
+			tag := field.Tag.Get("m")
+			if tag == "-" || field.Name == "_" {
+				continue
+			}
+
+			// Only embedded structs without tags that would be stored as a nested struct are
+			// flattened. Embedded pointers and types with their own encoding, like time.Time,
+			// are stored like any other field.
+			if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+				if _, ok := getColumnCodec(field.Type).(*structCodec); ok {
+					collect(field.Type, field.Index, depth+1)
+					continue
+				}
+			}
+
+			candidates = append(candidates, candidate{
+				field: field,
+				depth: depth,
+			})
+		}
+	}
+	collect(typ, nil, 0)
+
+	shallowest := map[string]int{}
+	counts := map[string]int{}
+	for _, item := range candidates {
+		depth, ok := shallowest[item.field.Name]
+		switch {
+		case !ok || item.depth < depth:
+			shallowest[item.field.Name], counts[item.field.Name] = item.depth, 1
+		case item.depth == depth:
+			counts[item.field.Name]++
+		}
+	}
+
+	fields = make([]reflect.StructField, 0, len(candidates))
+	reported := map[string]bool{}
+	for _, item := range candidates {
+		name := item.field.Name
+		if item.depth != shallowest[name] {
+			continue
+		}
+
+		if counts[name] > 1 {
+			if !reported[name] {
+				ambiguous = append(ambiguous, name)
+				reported[name] = true
+			}
+			continue
+		}
+
+		fields = append(fields, item.field)
+	}
+
+	return fields, ambiguous
+}
+
+// getFieldNames returns the names of the provided fields separated by commas.
+func getFieldNames(fields []Field) string {
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = field.Name()
+	}
+	return strings.Join(names, ", ")
+}
+
+// parseTagValue parses a value provided in a tag, like a default, into the provided type. Strings,
+// numbers, booleans, durations and times in RFC 3339 format are supported as well as any type
+// that implements encoding.TextUnmarshaler.
+func parseTagValue(value string, typ reflect.Type) (reflect.Value, error) {
+	if typ.Kind() == reflect.Ptr {
+		elem, err := parseTagValue(value, typ.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+
+		pointer := reflect.New(typ.Elem())
+		pointer.Elem().Set(elem)
+		return pointer, nil
+	}
+
+	if reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		pointer := reflect.New(typ)
+		if err := pointer.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)); err != nil {
+			return reflect.Value{}, fmt.Errorf("cannot parse [%s] as %s: %v", value, typ, err)
+		}
+
+		return pointer.Elem(), nil
+	}
+
+	var parsed interface{}
+	var err error
+	switch {
+	case typ == durationType:
+		parsed, err = time.ParseDuration(value)
+	case typ.Kind() == reflect.String:
+		parsed = value
+	case typ.Kind() == reflect.Bool:
+		parsed, err = strconv.ParseBool(value)
+	case typ.Kind() >= reflect.Int && typ.Kind() <= reflect.Int64:
+		parsed, err = strconv.ParseInt(value, 10, typ.Bits())
+	case typ.Kind() >= reflect.Uint && typ.Kind() <= reflect.Uint64:
+		parsed, err = strconv.ParseUint(value, 10, typ.Bits())
+	case typ.Kind() == reflect.Float32 || typ.Kind() == reflect.Float64:
+		parsed, err = strconv.ParseFloat(value, typ.Bits())
+	default:
+		return reflect.Value{}, fmt.Errorf("%s cannot be parsed from a tag", typ)
+	}
+
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("cannot parse [%s] as %s: %v", value, typ, err)
+	}
+
+	return reflect.ValueOf(parsed).Convert(typ), nil
+}
+
+// tagNames are the tags that can be used on a field.
+var tagNames = map[string]bool{
+	"pk": true, "name": true, "readonly": true, "created_at": true, "updated_at": true,
+	"deleted_at": true, "version": true, "min": true, "max": true, "notempty": true, "maxlen": true,
+	"regex": true, "oneof": true, "default": true, "serial": true, "fk": true, "json": true,
+	"unique": true, "uq": true, "index": true, "idx": true,
+}
+
+// tagAfterRegex returns the name of the first tag that follows a comma in the expression of a regex
+// tag. The expression takes the rest of the tag, so a tag after it would become part of the
+// expression rather than being applied.
+func tagAfterRegex(expression string) string {
+	items := strings.Split(expression, ",")
+	for _, item := range items[1:] {
+		if name := strings.SplitN(item, ":", 2)[0]; tagNames[name] {
+			return name
+		}
+	}
+
+	return ""
 }
 
 func getFlags(tag string) map[string]string {
 	flags := strings.Split(tag, ",")
 	items := map[string]string{}
-	for _, flag := range flags {
+	for i, flag := range flags {
+		// A regular expression can contain commas, so it takes the rest of the tag.
+		if strings.HasPrefix(flag, "regex:") {
+			flag = strings.Join(flags[i:], ",")
+			items["regex"] = strings.TrimPrefix(flag, "regex:")
+			break
+		}
+
 		split := strings.SplitN(flag, ":", 2)
 		switch len(split) {
 		case 1:
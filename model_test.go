@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestGetBaseTypeOf(t *testing.T) {
@@ -12,21 +13,23 @@ func TestGetBaseTypeOf(t *testing.T) {
 	}
 
 	t.Run("simple", func(t *testing.T) {
-		typ := getBaseTypeOf(Simple{})
+		typ, err := getBaseTypeOf(Simple{})
+		assert.NoError(t, err)
 		assert.Equal(t, "Simple", typ.Name())
 		assert.Equal(t, reflect.Struct, typ.Kind())
 	})
 
 	t.Run("slice", func(t *testing.T) {
-		typ := getBaseTypeOf([]Simple{})
+		typ, err := getBaseTypeOf([]Simple{})
+		assert.NoError(t, err)
 		assert.Equal(t, "Simple", typ.Name())
 		assert.Equal(t, reflect.Struct, typ.Kind())
 	})
 
 	t.Run("invalid", func(t *testing.T) {
-		assert.Panics(t, func() {
-			_ = getBaseTypeOf(1)
-		})
+		_, err := getBaseTypeOf(1)
+		assert.Error(t, err)
+		assert.Equal(t, ModelErrorUnsupportedType, err.(*ModelError).Kind)
 	})
 }
 
@@ -40,7 +43,400 @@ func TestGetModelInfo(t *testing.T) {
 			Password   string
 			Healthy    bool
 		}
-		info := getModelInfo(DataNode{})
+		info, err := getModelInfo(DataNode{})
+		assert.NoError(t, err)
 		assert.Equal(t, "DataNode", info.Name())
 	})
+
+	t.Run("cached", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+			Name   string `m:"uq"`
+		}
+
+		info, err := getModelInfo(Item{})
+		assert.NoError(t, err)
+		pointerInfo, err := getModelInfo(&Item{})
+		assert.NoError(t, err)
+		assert.True(t, info == pointerInfo, "pointers should share the model")
+
+		sliceInfo, err := getModelInfo([]Item{})
+		assert.NoError(t, err)
+		assert.True(t, info == sliceInfo, "slices should share the model")
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+		}
+
+		results := make(chan Model, 10)
+		for i := 0; i < cap(results); i++ {
+			go func() {
+				info, _ := getModelInfo(Item{})
+				results <- info
+			}()
+		}
+
+		first := <-results
+		for i := 1; i < cap(results); i++ {
+			assert.True(t, first == <-results)
+		}
+	})
+
+	t.Run("lookups", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+			Name   string `m:"uq"`
+			Kind   string `m:"index"`
+		}
+
+		info, err := getModelInfo(Item{})
+		assert.NoError(t, err)
+
+		field := info.Fields().GetByName("Name")
+		assert.NotNil(t, field)
+		assert.Equal(t, field, info.Fields().GetById(field.FieldId()))
+		assert.Nil(t, info.Fields().GetByName("Missing"))
+		assert.Nil(t, info.Fields().GetById(0))
+
+		constraint := info.UniqueConstraints().GetByName("uq_name")
+		assert.NotNil(t, constraint)
+		assert.Equal(t, constraint, info.UniqueConstraints().GetById(constraint.UniqueConstraintId()))
+		assert.Nil(t, info.UniqueConstraints().GetByName("uq_missing"))
+
+		index := info.Indexes().GetByName("ix_kind")
+		assert.NotNil(t, index)
+		assert.Equal(t, index, info.Indexes().GetById(index.IndexId()))
+		assert.Nil(t, info.Indexes().GetByName("ix_missing"))
+	})
+}
+
+func TestGetModelInfo_UniqueConstraints(t *testing.T) {
+	t.Run("generated names", func(t *testing.T) {
+		type Item struct {
+			ItemId  uint64 `m:"pk"`
+			Email   string `m:"uq"`
+			Account string `m:"uq:uq_account_handle"`
+			Handle  string `m:"uq:uq_account_handle"`
+			Phone   string `m:"uq"`
+		}
+
+		for i := 0; i < 10; i++ {
+			// Build the model directly so that the order is not just coming from the cache.
+			info, err := newModelInfo(reflect.TypeOf(Item{}))
+			assert.NoError(t, err)
+			constraints := info.UniqueConstraints().GetAll()
+			names := make([]string, len(constraints))
+			for j, constraint := range constraints {
+				names[j] = constraint.Name()
+			}
+			assert.Equal(t, []string{"uq_email", "uq_account_handle", "uq_phone"}, names)
+			assert.Len(t, info.UniqueConstraints().GetByName("uq_account_handle").Fields().GetAll(), 2)
+		}
+	})
+
+	t.Run("generated name conflicts with tag", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+			Email  string `m:"uq"`
+			Other  string `m:"uq:uq_email"`
+		}
+
+		_, err := newModelInfo(reflect.TypeOf(Item{}))
+		assert.EqualError(t, err, "model Item field [Other]: duplicate constraint: unique constraint name [uq_email] is used by fields [Email] and [Other]")
+	})
+
+	t.Run("generated names conflict", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+			Email  string `m:"uq"`
+			EMAIL  string `m:"uq"`
+		}
+
+		_, err := newModelInfo(reflect.TypeOf(Item{}))
+		assert.Error(t, err)
+	})
+}
+
+func TestGetModelInfo_Tags(t *testing.T) {
+	t.Run("ignored fields", func(t *testing.T) {
+		type Item struct {
+			ItemId  uint64 `m:"pk"`
+			Cache   map[string]func()
+			Scratch chan int `m:"-"`
+		}
+
+		_, err := getModelInfo(Item{})
+		assert.Error(t, err, "fields that cannot be stored must be ignored")
+
+		type IgnoredItem struct {
+			ItemId  uint64   `m:"pk"`
+			Scratch chan int `m:"-"`
+		}
+
+		info, err := getModelInfo(IgnoredItem{})
+		assert.NoError(t, err)
+		assert.Len(t, info.Fields().GetAll(), 1)
+		assert.Nil(t, info.Fields().GetByName("Scratch"))
+	})
+
+	t.Run("column names", func(t *testing.T) {
+		// Both types have the same name, so they represent the same model before and after the
+		// field is renamed.
+		var before, after Model
+		{
+			type Item struct {
+				ItemId  uint64 `m:"pk"`
+				Address string `m:"uq,index"`
+			}
+
+			info, err := newModelInfo(reflect.TypeOf(Item{}))
+			assert.NoError(t, err)
+			before = info
+		}
+		{
+			type Item struct {
+				ItemId    uint64 `m:"pk"`
+				IPAddress string `m:"uq,index,name:Address"`
+			}
+
+			info, err := newModelInfo(reflect.TypeOf(Item{}))
+			assert.NoError(t, err)
+			after = info
+		}
+
+		beforeField, afterField := before.Fields().GetByName("Address"), after.Fields().GetByName("IPAddress")
+		assert.Equal(t, "Address", afterField.ColumnName())
+		assert.Equal(t, beforeField.FieldId(), afterField.FieldId())
+		assert.Equal(t, before.UniqueConstraints().GetAll()[0].UniqueConstraintId(), after.UniqueConstraints().GetAll()[0].UniqueConstraintId())
+		assert.Equal(t, before.Indexes().GetAll()[0].IndexId(), after.Indexes().GetAll()[0].IndexId())
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		type Item struct {
+			ItemId  uint64        `m:"pk"`
+			Status  string        `m:"default:pending"`
+			Retries int32         `m:"default:3"`
+			Timeout time.Duration `m:"default:5s"`
+			Enabled *bool         `m:"default:true"`
+		}
+
+		info, err := getModelInfo(Item{})
+		assert.NoError(t, err)
+
+		item := applyDefaults(info, reflect.ValueOf(Item{ItemId: 1, Retries: 7})).Interface().(Item)
+		assert.Equal(t, "pending", item.Status)
+		assert.Equal(t, int32(7), item.Retries, "fields with values should not be changed")
+		assert.Equal(t, 5*time.Second, item.Timeout)
+		if assert.NotNil(t, item.Enabled) {
+			assert.True(t, *item.Enabled)
+		}
+	})
+
+	t.Run("invalid timestamps", func(t *testing.T) {
+		type Item struct {
+			ItemId    uint64    `m:"pk"`
+			CreatedAt int64     `m:"created_at"`
+			UpdatedAt time.Time `m:"updated_at"`
+			ChangedAt time.Time `m:"updated_at"`
+		}
+
+		_, err := getModelInfo(Item{})
+		assert.EqualError(t, err, "model Item field [CreatedAt]: invalid tag: created_at must be a time.Time or *time.Time; "+
+			"model Item field [ChangedAt]: invalid tag: updated_at is already used by [UpdatedAt]")
+	})
+
+	t.Run("invalid version", func(t *testing.T) {
+		type Item struct {
+			ItemId   uint64 `m:"pk,version"`
+			Version  string `m:"version"`
+			Revision int    `m:"version"`
+		}
+
+		_, err := getModelInfo(Item{})
+		assert.EqualError(t, err, "model Item field [ItemId]: invalid tag: version cannot be part of the primary key; "+
+			"model Item field [Version]: invalid tag: version must be an integer; "+
+			"model Item field [Revision]: invalid tag: version is already used by [ItemId]")
+	})
+
+	t.Run("invalid validation rules", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+			Title  string `m:"min:1"`
+			Count  int    `m:"max:lots"`
+			Price  int    `m:"maxlen:8"`
+			Code   string `m:"regex:[a-"`
+			Color  int    `m:"oneof:1|red"`
+		}
+
+		_, err := getModelInfo(Item{})
+		assert.EqualError(t, err, "model Item field [Title]: invalid tag: min can only be used on numbers, not string; "+
+			"model Item field [Count]: invalid tag: max cannot parse [lots] as int: strconv.ParseInt: parsing \"lots\": invalid syntax; "+
+			"model Item field [Price]: invalid tag: maxlen can only be used on strings, slices and maps, not int; "+
+			"model Item field [Code]: invalid tag: regex is not valid: error parsing regexp: missing closing ]: `[a-`; "+
+			"model Item field [Color]: invalid tag: oneof cannot parse [red] as int: strconv.ParseInt: parsing \"red\": invalid syntax")
+	})
+
+	t.Run("regex with commas", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+			Code   string `m:"notempty,regex:^[a-z]{2,4}$"`
+		}
+
+		info, err := getModelInfo(Item{})
+		assert.NoError(t, err)
+		rules := info.Fields().GetByName("Code").(*modelField).rules
+		if assert.Len(t, rules, 2) {
+			assert.Equal(t, "notempty", rules[0].tag)
+			assert.Equal(t, "regex:^[a-z]{2,4}$", rules[1].tag)
+		}
+	})
+
+	t.Run("regex followed by a tag", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+			Code   string `m:"regex:^a+$,notempty"`
+		}
+
+		_, err := getModelInfo(Item{})
+		assert.EqualError(t, err, "model Item field [Code]: invalid tag: regex must be the last tag, [notempty] follows it")
+	})
+
+	t.Run("invalid default", func(t *testing.T) {
+		type Item struct {
+			ItemId  uint64 `m:"pk"`
+			Retries int32  `m:"default:many"`
+		}
+
+		_, err := getModelInfo(Item{})
+		assert.Error(t, err)
+		assert.Equal(t, ModelErrorInvalidDefault, err.(ModelErrors)[0].Kind)
+	})
+}
+
+type testTimestamps struct {
+	CreatedAt time.Time `m:"index"`
+	UpdatedAt time.Time
+}
+
+type testOwner struct {
+	OwnerId uint64
+}
+
+func TestGetModelInfo_Embedded(t *testing.T) {
+	t.Run("flattened", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+			testTimestamps
+			Name string
+		}
+
+		info, err := getModelInfo(Item{})
+		assert.NoError(t, err)
+
+		names := make([]string, 0)
+		for _, field := range info.Fields().GetAll() {
+			names = append(names, field.Name())
+		}
+		assert.Equal(t, []string{"ItemId", "CreatedAt", "UpdatedAt", "Name"}, names)
+		assert.Equal(t, []int{1, 0}, info.Fields().GetByName("CreatedAt").Reflection().Index)
+		assert.NotNil(t, info.Indexes().GetByName("ix_createdat"), "tags on embedded fields should be applied")
+	})
+
+	t.Run("outer fields hide embedded fields", func(t *testing.T) {
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+			testTimestamps
+			UpdatedAt int64
+		}
+
+		info, err := getModelInfo(Item{})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{2}, info.Fields().GetByName("UpdatedAt").Reflection().Index)
+		assert.Len(t, info.Fields().GetAll(), 3)
+	})
+
+	t.Run("ambiguous", func(t *testing.T) {
+		type Audit struct {
+			CreatedAt time.Time
+		}
+
+		type Item struct {
+			ItemId uint64 `m:"pk"`
+			testTimestamps
+			Audit
+		}
+
+		_, err := getModelInfo(Item{})
+		assert.EqualError(t, err, "model Item field [CreatedAt]: unsupported field: the field is declared by more than one embedded struct")
+	})
+
+	t.Run("tagged and pointers are not flattened", func(t *testing.T) {
+		type Item struct {
+			ItemId         uint64 `m:"pk"`
+			testTimestamps `m:"json"`
+			*testOwner
+		}
+
+		info, err := getModelInfo(Item{})
+		assert.NoError(t, err)
+		assert.NotNil(t, info.Fields().GetByName("testTimestamps"))
+		assert.NotNil(t, info.Fields().GetByName("testOwner"))
+		assert.Nil(t, info.Fields().GetByName("CreatedAt"))
+	})
+}
+
+type testNamedNode struct {
+	NodeId  uint64 `m:"pk"`
+	Address string `m:"uq"`
+}
+
+func (testNamedNode) ModelName() string {
+	return "data_node"
+}
+
+type testConflictingNamedNode struct {
+	_      struct{} `m:"model:node"`
+	NodeId uint64   `m:"pk"`
+}
+
+func (*testConflictingNamedNode) ModelName() string {
+	return "data_node"
+}
+
+func TestGetModelInfo_ModelName(t *testing.T) {
+	t.Run("stable identity", func(t *testing.T) {
+		type DataNode struct {
+			_       struct{} `m:"model:data_node"`
+			NodeId  uint64   `m:"pk"`
+			Address string   `m:"uq"`
+		}
+
+		named, err := getModelInfo(testNamedNode{})
+		assert.NoError(t, err)
+
+		tagged, err := getModelInfo(DataNode{})
+		assert.NoError(t, err)
+		assert.Equal(t, "DataNode", tagged.Name())
+		assert.Nil(t, tagged.Fields().GetByName("_"), "blank fields should not be stored")
+
+		assert.Equal(t, named.ModelId(), tagged.ModelId())
+		assert.Equal(t, named.Fields().GetByName("Address").FieldId(), tagged.Fields().GetByName("Address").FieldId())
+		assert.Equal(t, named.UniqueConstraints().GetAll()[0].UniqueConstraintId(), tagged.UniqueConstraints().GetAll()[0].UniqueConstraintId())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		type Item struct {
+			_      struct{} `m:"model:,pk"`
+			ItemId uint64   `m:"pk"`
+		}
+
+		_, err := getModelInfo(Item{})
+		assert.EqualError(t, err, "model Item field [_]: invalid tag: model must not be empty; "+
+			"model Item field [_]: unknown tag: [pk] cannot be used on a blank field")
+
+		_, err = getModelInfo(testConflictingNamedNode{})
+		assert.EqualError(t, err, "model testConflictingNamedNode: invalid tag: ModelName returns [data_node] but the model tag is [node]")
+	})
 }
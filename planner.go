@@ -0,0 +1,551 @@
+package mellivora
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/elliotcourant/buffers"
+	"sort"
+	"strings"
+)
+
+// AccessPath is the way that a query reads the records of a model from the store.
+type AccessPath int
+
+const (
+	// AccessPathDatumScan reads datums directly, either every datum of the model or a range of
+	// primary keys.
+	AccessPathDatumScan AccessPath = iota
+
+	// AccessPathPrimaryKeyLookup reads the datums for specific primary keys.
+	AccessPathPrimaryKeyLookup
+
+	// AccessPathUniqueLookup reads the unique keys for specific values of a unique constraint and
+	// then retrieves the datum that each of them belongs to.
+	AccessPathUniqueLookup
+
+	// AccessPathIndexScan reads a range of an index and then retrieves the datum for each of the
+	// index entries.
+	AccessPathIndexScan
+)
+
+func (a AccessPath) String() string {
+	switch a {
+	case AccessPathDatumScan:
+		return "datum scan"
+	case AccessPathPrimaryKeyLookup:
+		return "primary key lookup"
+	case AccessPathUniqueLookup:
+		return "unique key lookup"
+	case AccessPathIndexScan:
+		return "index scan"
+	default:
+		return fmt.Sprintf("AccessPath(%d)", int(a))
+	}
+}
+
+// Plan describes how a query will read its records, it is returned by Query.Explain.
+type Plan struct {
+	// Model is the name of the model being queried.
+	Model string
+
+	// AccessPath is how the records will be read.
+	AccessPath AccessPath
+
+	// Index is the name of the unique constraint or index used by the access path, it is empty
+	// when the datums are read directly.
+	Index string
+
+	// Bounded is true when only part of the keys for the access path will be read.
+	Bounded bool
+
+	// Reverse is true when the keys will be read from the end of the range to the start.
+	Reverse bool
+
+	// Sorted is true when the records need to be sorted after they are read to satisfy the order
+	// of the query.
+	Sorted bool
+
+	// EstimatedKeys is the number of keys that will be read by the query.
+	EstimatedKeys int
+
+	// ResidualFilters are the filters that will be evaluated against each record that is read
+	// because they are not satisfied by the access path. Every filter must be true for a record
+	// to be returned.
+	ResidualFilters []string
+}
+
+// String returns a human readable description of the plan.
+func (p Plan) String() string {
+	description := p.AccessPath.String()
+	if p.AccessPath == AccessPathDatumScan {
+		if p.Bounded {
+			description = "datum range scan"
+		} else {
+			description = "full datum scan"
+		}
+	} else if p.AccessPath == AccessPathIndexScan && p.Bounded {
+		description = "index range scan"
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("%s on %s", description, p.Model))
+	if p.Index != "" {
+		builder.WriteString(fmt.Sprintf(" using %s", p.Index))
+	}
+
+	if p.Reverse {
+		builder.WriteString(" (reverse)")
+	}
+
+	builder.WriteString(fmt.Sprintf("\n  estimated keys: %d", p.EstimatedKeys))
+
+	if len(p.ResidualFilters) > 0 {
+		builder.WriteString(fmt.Sprintf("\n  residual filters: %s", strings.Join(p.ResidualFilters, " AND ")))
+	}
+
+	if p.Sorted {
+		builder.WriteString("\n  sorted in memory")
+	}
+
+	return builder.String()
+}
+
+// keyRange is the range of keys that a query will read. Every key in the range starts with the
+// prefix. The start key is inclusive and the end key is exclusive, if either are nil then the
+// range is not bounded on that side.
+type keyRange struct {
+	prefix []byte
+	start  []byte
+	end    []byte
+}
+
+// queryPlan describes how the records for a query will be read from the store.
+type queryPlan struct {
+	path AccessPath
+
+	// index is the index being scanned for an index scan.
+	index Index
+
+	// uniqueConstraint is the constraint being read for a unique lookup.
+	uniqueConstraint UniqueConstraint
+
+	// keys is the range of keys read by a datum or index scan.
+	keys keyRange
+
+	// lookups are the keys read by a primary key or unique lookup, they are sorted and do not
+	// contain duplicates.
+	lookups [][]byte
+
+	// consumed are the fields whose conditions are satisfied by the access path.
+	consumed map[string]bool
+
+	// reverse is true when the keys should be read from the end of the range to the start.
+	reverse bool
+
+	// ordered is true when reading the keys in the direction of the plan satisfies the order of
+	// the query. If this is false and the query is ordered then the results need to be sorted.
+	ordered bool
+}
+
+// Explain returns the plan that will be used to read the records for the query. To estimate the
+// keys read, the keys in the ranges that would be scanned are counted without reading their values.
+// This still iterates over every key in the range, so explaining a scan of an entire model costs
+// about as much as reading its keys.
+func (q *Query) Explain() (Plan, error) {
+	if err := q.validate(); err != nil {
+		return Plan{}, err
+	}
+
+	if _, err := q.buildCriteria(); err != nil {
+		return Plan{}, err
+	}
+
+	plan := q.plan()
+
+	explained := Plan{
+		Model:           q.model.Name(),
+		AccessPath:      plan.path,
+		Bounded:         plan.keys.start != nil || plan.keys.end != nil,
+		Reverse:         plan.reverse,
+		Sorted:          !plan.ordered,
+		ResidualFilters: q.residualFilters(plan),
+	}
+
+	switch plan.path {
+	case AccessPathPrimaryKeyLookup:
+		explained.EstimatedKeys = len(plan.lookups)
+	case AccessPathUniqueLookup:
+		explained.Index = plan.uniqueConstraint.Name()
+		explained.EstimatedKeys = len(plan.lookups) * 2
+	default:
+		// If every record read is returned then the scan will stop once the limit is met.
+		count := 0
+		if err := q.scanKeys(plan, true, func(key, value []byte) (bool, error) {
+			count++
+			return len(explained.ResidualFilters) > 0 || !q.isLimitMet(plan, count), nil
+		}); err != nil {
+			return Plan{}, err
+		}
+
+		explained.EstimatedKeys = count
+		if plan.path == AccessPathIndexScan {
+			explained.Index = plan.index.Name()
+
+			// Each index entry is followed by a read of its datum.
+			explained.EstimatedKeys = count * 2
+		}
+	}
+
+	return explained, nil
+}
+
+// plan determines the best way to read the records for the query. Equality on every field of the
+// primary key or a unique constraint is read directly. Conditions on the leading column of the
+// primary key or an index are turned into a range of keys that can be read using a seek rather
+// than reading the entire table. All of the filters of the query are still evaluated against each
+// record read.
+func (q *Query) plan() queryPlan {
+	builder := newDatumBuilder(q.model, q.destination, false)
+
+	plan := queryPlan{
+		path: AccessPathDatumScan,
+		keys: keyRange{
+			prefix: builder.DatumPrefix(),
+		},
+		consumed: map[string]bool{},
+	}
+
+	conditions := q.leadingConditions()
+
+	primaryKey := q.model.PrimaryKey().GetAll()
+	if lookups, ok := q.lookupsFor(plan.keys.prefix, primaryKey, conditions); ok {
+		plan.path, plan.lookups = AccessPathPrimaryKeyLookup, lookups
+		plan.consume(primaryKey...)
+		plan.ordered = len(q.orderBy) == 0
+		return plan
+	}
+
+	for _, uniqueConstraint := range q.model.UniqueConstraints().GetAll() {
+		uniqueFields := uniqueConstraint.Fields().GetAll()
+		if lookups, ok := q.lookupsFor(builder.UniquePrefix(uniqueConstraint), uniqueFields, conditions); ok {
+			plan.path, plan.uniqueConstraint, plan.lookups = AccessPathUniqueLookup, uniqueConstraint, lookups
+			plan.consume(uniqueFields...)
+			plan.ordered = len(q.orderBy) == 0
+			return plan
+		}
+	}
+
+	if len(primaryKey) > 0 {
+		if keys, ok := q.rangeFor(plan.keys.prefix, primaryKey[0], conditions); ok {
+			plan.keys = keys
+			plan.consume(primaryKey[0])
+			plan.reverse, plan.ordered = q.orderFor(primaryKey, conditions)
+			return plan
+		}
+	}
+
+	for _, index := range q.model.Indexes().GetAll() {
+		indexFields := index.Fields().GetAll()
+		if keys, ok := q.rangeFor(builder.IndexPrefix(index), indexFields[0], conditions); ok {
+			plan.path, plan.index, plan.keys = AccessPathIndexScan, index, keys
+			plan.consume(indexFields[0])
+			plan.reverse, plan.ordered = q.orderFor(getIndexKeyFields(q.model, index), conditions)
+			return plan
+		}
+	}
+
+	// If there are no conditions that can be used to limit the keys being read then we can at
+	// least try to read the keys in an order that satisfies the query.
+	if reverse, ordered := q.orderFor(primaryKey, conditions); ordered || len(q.orderBy) == 0 {
+		plan.reverse, plan.ordered = reverse, ordered
+		return plan
+	}
+
+	for _, index := range q.model.Indexes().GetAll() {
+		if reverse, ordered := q.orderFor(getIndexKeyFields(q.model, index), conditions); ordered {
+			plan.path, plan.index = AccessPathIndexScan, index
+			plan.keys = keyRange{
+				prefix: builder.IndexPrefix(index),
+			}
+			plan.reverse, plan.ordered = reverse, ordered
+			return plan
+		}
+	}
+
+	return plan
+}
+
+// consume marks the conditions on the provided fields as satisfied by the access path.
+func (p *queryPlan) consume(fields ...Field) {
+	for _, field := range fields {
+		p.consumed[field.Name()] = true
+	}
+}
+
+// leadingConditions returns the conditions of the query by field name. If the query has multiple
+// filter groups then the records could come from anywhere in the table, so no conditions are
+// returned.
+func (q *Query) leadingConditions() map[string]Condition {
+	conditions := map[string]Condition{}
+	if len(q.filters) != 1 {
+		return conditions
+	}
+
+	for fieldName, value := range q.filters[0] {
+		conditions[fieldName] = newCondition(value)
+	}
+
+	return conditions
+}
+
+// residualFilters returns the filters of the query that are not satisfied by the plan's access
+// path, sorted by field name. When the query has multiple filter groups they are returned as a
+// single filter.
+func (q *Query) residualFilters(plan queryPlan) []string {
+	describeGroup := func(filter Ex) []string {
+		descriptions := make([]string, 0, len(filter))
+		for fieldName, value := range filter {
+			if plan.consumed[fieldName] {
+				continue
+			}
+
+			descriptions = append(descriptions, newCondition(value).describe(fieldName))
+		}
+		sort.Strings(descriptions)
+		return descriptions
+	}
+
+	filters := make([]string, 0)
+	switch len(q.filters) {
+	case 0:
+	case 1:
+		filters = describeGroup(q.filters[0])
+	default:
+		groups := make([]string, len(q.filters))
+		for i, filter := range q.filters {
+			groups[i] = fmt.Sprintf("(%s)", strings.Join(describeGroup(filter), " AND "))
+		}
+		filters = append(filters, strings.Join(groups, " OR "))
+	}
+
+	// Soft deleted records are filtered out after they are read.
+	if deletedAt := q.model.(*modelInfo).deletedAt; deletedAt != nil && !q.withDeleted {
+		filters = append(filters, IsNull().describe(deletedAt.Name()))
+	}
+
+	return filters
+}
+
+// lookupsFor returns the keys for each of the values that the provided fields are being compared
+// against for equality. Every field must be compared for equality, only one of the fields may be
+// compared against a list of values.
+func (q *Query) lookupsFor(prefix []byte, fields []Field, conditions map[string]Condition) ([][]byte, bool) {
+	if len(fields) == 0 {
+		return nil, false
+	}
+
+	keys := [][]byte{prefix}
+	for _, field := range fields {
+		condition, ok := conditions[field.Name()]
+		if !ok || (condition.operator != operatorEqual && condition.operator != operatorIn) {
+			return nil, false
+		}
+
+		if condition.operator == operatorIn && len(keys) > 1 {
+			return nil, false
+		}
+
+		codec, err := getFieldCodec(field)
+		if err != nil || codec == nil {
+			return nil, false
+		}
+
+		encoded := make([][]byte, 0, len(condition.values))
+		for _, value := range condition.values {
+			converted, err := convertFilterValue(value, field.Reflection().Type)
+
+			// Values that cannot be represented by the type of the field will never match anything.
+			var lossy *lossyConversionError
+			if errors.As(err, &lossy) {
+				continue
+			} else if err != nil {
+				return nil, false
+			}
+
+			// Nulls are not written to unique keys and are not allowed in primary keys, so a null
+			// value will never match anything.
+			if isNullValue(converted) {
+				continue
+			}
+
+			buf := buffers.NewBytesBuffer()
+			if err := codec.AppendKey(buf, converted); err != nil {
+				return nil, false
+			}
+			encoded = append(encoded, buf.Bytes())
+		}
+
+		next := make([][]byte, 0, len(keys)*len(encoded))
+		for _, key := range keys {
+			for _, value := range encoded {
+				next = append(next, append(append(make([]byte, 0, len(key)+len(value)), key...), value...))
+			}
+		}
+		keys = next
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	lookups := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if len(lookups) == 0 || !bytes.Equal(lookups[len(lookups)-1], key) {
+			lookups = append(lookups, key)
+		}
+	}
+
+	return lookups, true
+}
+
+// rangeFor returns the range of keys that satisfy the condition on the provided field. The field
+// must be the first value in keys with the provided prefix.
+func (q *Query) rangeFor(prefix []byte, field Field, conditions map[string]Condition) (keyRange, bool) {
+	keys := keyRange{
+		prefix: prefix,
+	}
+
+	condition, ok := conditions[field.Name()]
+	if !ok {
+		return keys, false
+	}
+
+	codec, err := getFieldCodec(field)
+	if err != nil || codec == nil {
+		return keys, false
+	}
+
+	// Equality can be used for any type, but ranges can only be used when the keys sort the same
+	// way that the values do.
+	if condition.isRange() && !isOrderedCodec(codec) {
+		return keys, false
+	}
+
+	bounds := make([]filterKey, len(condition.values))
+	for i, value := range condition.values {
+		bound, err := encodeFilterKey(codec, prefix, value, field.Reflection().Type)
+		if err != nil {
+			return keys, false
+		}
+		bounds[i] = bound
+	}
+
+	// Keys are prefix free, so every key where the field has a given value starts with the
+	// encoded value. The end of the prefix for that value is used when the value itself should be
+	// included at the end of a range, or excluded at the start of one. A fraction is never included
+	// so it is treated like the whole number below it, excluded at the start and included at the
+	// end.
+	lower := func(bound filterKey, inclusive bool) (start []byte, ok bool) {
+		switch {
+		case bound.outside != 0:
+			// A bound below every value does not limit the range, one above every value cannot be
+			// satisfied.
+			return nil, bound.outside < 0
+		case bound.fraction || !inclusive:
+			return prefixEnd(bound.key), true
+		default:
+			return bound.key, true
+		}
+	}
+	upper := func(bound filterKey, inclusive bool) (end []byte, ok bool) {
+		switch {
+		case bound.outside != 0:
+			return nil, bound.outside > 0
+		case bound.fraction || inclusive:
+			return prefixEnd(bound.key), true
+		default:
+			return bound.key, true
+		}
+	}
+
+	startOk, endOk := true, true
+	switch condition.operator {
+	case operatorEqual:
+		if startOk = bounds[0].outside == 0 && !bounds[0].fraction; startOk {
+			keys.start, keys.end = bounds[0].key, prefixEnd(bounds[0].key)
+		}
+	case operatorGreaterThan:
+		keys.start, startOk = lower(bounds[0], false)
+	case operatorGreaterThanOrEqual:
+		keys.start, startOk = lower(bounds[0], true)
+	case operatorLessThan:
+		keys.end, endOk = upper(bounds[0], false)
+	case operatorLessThanOrEqual:
+		keys.end, endOk = upper(bounds[0], true)
+	case operatorBetween:
+		keys.start, startOk = lower(bounds[0], true)
+		keys.end, endOk = upper(bounds[1], true)
+	default:
+		return keys, false
+	}
+
+	// When no value of the field can satisfy the condition an empty range is scanned.
+	if !startOk || !endOk {
+		keys.start, keys.end = prefix, prefix
+	}
+
+	return keys, true
+}
+
+// orderFor returns whether reading keys made up of the provided fields would satisfy the order of
+// the query, and if they would need to be read in reverse to do so. If the first field is being
+// compared for equality then the next field can also be used to order the query.
+func (q *Query) orderFor(keyFields []Field, conditions map[string]Condition) (reverse bool, ordered bool) {
+	if len(q.orderBy) == 0 {
+		return false, true
+	}
+
+	if len(keyFields) > 1 {
+		if condition, ok := conditions[keyFields[0].Name()]; ok && condition.operator == operatorEqual &&
+			q.orderBy[0].field != keyFields[0].Name() {
+			keyFields = keyFields[1:]
+		}
+	}
+
+	if len(q.orderBy) > len(keyFields) {
+		return false, false
+	}
+
+	reverse = q.orderBy[0].descending
+	for i, order := range q.orderBy {
+		if order.field != keyFields[i].Name() || order.descending != reverse {
+			return false, false
+		}
+	}
+
+	return reverse, true
+}
+
+// getIndexKeyFields returns the fields that make up the keys of an index, which are the indexed
+// fields followed by the primary key.
+func getIndexKeyFields(model Model, index Index) []Field {
+	indexFields, primaryKey := index.Fields().GetAll(), model.PrimaryKey().GetAll()
+	keyFields := make([]Field, 0, len(indexFields)+len(primaryKey))
+	keyFields = append(keyFields, indexFields...)
+	return append(keyFields, primaryKey...)
+}
+
+// prefixEnd returns the first key that is greater than every key that starts with the provided
+// prefix. If there is no such key then nil is returned.
+func prefixEnd(prefix []byte) []byte {
+	end := append(make([]byte, 0, len(prefix)), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package mellivora
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestQuery_Explain(t *testing.T) {
+	type Item struct {
+		ItemId   uint64 `m:"pk"`
+		Code     string `m:"uq"`
+		Category string `m:"index"`
+		Price    int32
+	}
+
+	items := make([]Item, 0, 10)
+	for i := uint64(1); i <= 10; i++ {
+		items = append(items, Item{
+			ItemId:   i,
+			Code:     string(rune('a' + i)),
+			Category: []string{"even", "odd"}[i%2],
+			Price:    int32(i * 100),
+		})
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	err = txn.Insert(items)
+	assert.NoError(t, err)
+
+	t.Run("full datum scan", func(t *testing.T) {
+		plan, err := txn.Model(Item{}).Where(Ex{
+			"Price": 500,
+		}).Explain()
+		assert.NoError(t, err)
+		assert.Equal(t, AccessPathDatumScan, plan.AccessPath)
+		assert.False(t, plan.Bounded)
+		assert.Equal(t, 10, plan.EstimatedKeys)
+		assert.Equal(t, []string{"Price = 500"}, plan.ResidualFilters)
+		assert.Equal(t, "full datum scan on Item\n  estimated keys: 10\n  residual filters: Price = 500", plan.String())
+	})
+
+	t.Run("primary key lookup", func(t *testing.T) {
+		query := txn.Model(Item{}).Where(Ex{
+			"ItemId": []uint64{3, 5, 3, 42},
+		})
+		plan, err := query.Explain()
+		assert.NoError(t, err)
+		assert.Equal(t, AccessPathPrimaryKeyLookup, plan.AccessPath)
+		assert.Equal(t, 3, plan.EstimatedKeys)
+		assert.Empty(t, plan.ResidualFilters)
+
+		result := make([]Item, 0)
+		err = query.Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{items[2], items[4]}, result)
+	})
+
+	t.Run("unique key lookup", func(t *testing.T) {
+		query := txn.Model(Item{}).Where(Ex{
+			"Code":  "e",
+			"Price": Gt(100),
+		})
+		plan, err := query.Explain()
+		assert.NoError(t, err)
+		assert.Equal(t, AccessPathUniqueLookup, plan.AccessPath)
+		assert.Equal(t, "uq_code", plan.Index)
+		assert.Equal(t, 2, plan.EstimatedKeys)
+		assert.Equal(t, []string{"Price > 100"}, plan.ResidualFilters)
+
+		result := Item{}
+		err = query.Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, items[3], result)
+	})
+
+	t.Run("index scan", func(t *testing.T) {
+		query := txn.Model(Item{}).Where(Ex{
+			"Category": "odd",
+		})
+		plan, err := query.Explain()
+		assert.NoError(t, err)
+		assert.Equal(t, AccessPathIndexScan, plan.AccessPath)
+		assert.Equal(t, "ix_category", plan.Index)
+		assert.True(t, plan.Bounded)
+		assert.Equal(t, 10, plan.EstimatedKeys)
+
+		result := make([]Item, 0)
+		err = query.Select(&result)
+		assert.NoError(t, err)
+		assert.Len(t, result, 5)
+	})
+
+	t.Run("datum range scan with limit", func(t *testing.T) {
+		plan, err := txn.Model(Item{}).Where(Ex{
+			"ItemId": Gte(3),
+		}).OrderBy("ItemId DESC").Limit(2).Explain()
+		assert.NoError(t, err)
+		assert.Equal(t, AccessPathDatumScan, plan.AccessPath)
+		assert.True(t, plan.Bounded)
+		assert.True(t, plan.Reverse)
+		assert.False(t, plan.Sorted)
+		assert.Equal(t, 2, plan.EstimatedKeys)
+	})
+
+	t.Run("negative lower bound on unsigned key", func(t *testing.T) {
+		// -1 is below every uint64, so it does not limit the range rather than wrapping around.
+		query := txn.Model(Item{}).Where(Ex{
+			"ItemId": Gt(-1),
+		})
+		plan, err := query.Explain()
+		assert.NoError(t, err)
+		assert.Equal(t, AccessPathDatumScan, plan.AccessPath)
+		assert.False(t, plan.Bounded)
+		assert.Equal(t, 10, plan.EstimatedKeys)
+		assert.Empty(t, plan.ResidualFilters)
+
+		result := make([]Item, 0)
+		err = query.Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, items, result)
+	})
+
+	t.Run("bound out of range", func(t *testing.T) {
+		query := txn.Model(Item{}).Where(Ex{
+			"ItemId": Lte(-1),
+		})
+		plan, err := query.Explain()
+		assert.NoError(t, err)
+		assert.True(t, plan.Bounded)
+		assert.Equal(t, 0, plan.EstimatedKeys)
+
+		result := make([]Item, 0)
+		err = query.Select(&result)
+		assert.NoError(t, err)
+		assert.Empty(t, result)
+	})
+
+	t.Run("fraction bounds", func(t *testing.T) {
+		query := txn.Model(Item{}).Where(Ex{
+			"ItemId": Between(2.5, 4.5),
+		})
+		plan, err := query.Explain()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, plan.EstimatedKeys)
+
+		result := make([]Item, 0)
+		err = query.Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{items[2], items[3]}, result)
+	})
+
+	t.Run("lookup out of range", func(t *testing.T) {
+		query := txn.Model(Item{}).Where(Ex{
+			"ItemId": []interface{}{-1, 3, 1.5},
+		})
+		plan, err := query.Explain()
+		assert.NoError(t, err)
+		assert.Equal(t, AccessPathPrimaryKeyLookup, plan.AccessPath)
+		assert.Equal(t, 1, plan.EstimatedKeys)
+
+		result := make([]Item, 0)
+		err = query.Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{items[2]}, result)
+	})
+
+	t.Run("multiple filter groups", func(t *testing.T) {
+		plan, err := txn.Model(Item{}).Where(Ex{
+			"ItemId": 1,
+		}, Ex{
+			"Code":  "c",
+			"Price": 200,
+		}).Explain()
+		assert.NoError(t, err)
+		assert.Equal(t, AccessPathDatumScan, plan.AccessPath)
+		assert.Equal(t, []string{"(ItemId = 1) OR (Code = c AND Price = 200)"}, plan.ResidualFilters)
+	})
+
+	t.Run("sorted in memory", func(t *testing.T) {
+		plan, err := txn.Model(Item{}).OrderBy("Price").Explain()
+		assert.NoError(t, err)
+		assert.True(t, plan.Sorted)
+	})
+}
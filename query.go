@@ -1,8 +1,12 @@
 package mellivora
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/elliotcourant/buffers"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,9 +20,22 @@ type Query struct {
 	model       Model
 	txn         *Transaction
 	filters     []Ex
+	orderBy     []orderTerm
 
 	limit  int
 	offset int
+
+	// withDeleted includes records that have been soft deleted in the results.
+	withDeleted bool
+
+	// err is an error that was encountered while building the query, it is returned when the
+	// query is executed.
+	err error
+}
+
+type orderTerm struct {
+	field      string
+	descending bool
 }
 
 func (q *Query) InnerJoin(relatedModel interface{}) *Query {
@@ -48,14 +65,45 @@ func (q *Query) AndWhere(expression ...Ex) *Query {
 	return q
 }
 
+// OrderBy sorts the results of the query by the provided fields. Each expression is a field name
+// optionally followed by ASC or DESC, such as "Name" or "CreatedAt DESC".
+func (q *Query) OrderBy(expressions ...string) *Query {
+	for _, expression := range expressions {
+		parts := strings.Fields(expression)
+		if len(parts) == 0 {
+			continue
+		}
+
+		q.orderBy = append(q.orderBy, orderTerm{
+			field:      parts[0],
+			descending: len(parts) > 1 && strings.EqualFold(parts[1], "DESC"),
+		})
+	}
+
+	return q
+}
+
+// WithDeleted includes records that have been soft deleted in the results of the query.
+func (q *Query) WithDeleted() *Query {
+	q.withDeleted = true
+	return q
+}
+
 func (q *Query) Limit(limit int) *Query {
 	q.limit = limit
 	return q
 }
 
+func (q *Query) Offset(offset int) *Query {
+	q.offset = offset
+	return q
+}
+
 func (q *Query) Select(destination interface{}) error {
 	start := time.Now()
-	defer q.txn.db.logger.Tracef("select %T took %s", destination, time.Since(start))
+	defer func() {
+		q.txn.db.logger.Tracef("select %T took %s", destination, time.Since(start))
+	}()
 
 	dest := reflect.ValueOf(destination)
 	for dest.Kind() == reflect.Ptr {
@@ -63,75 +111,282 @@ func (q *Query) Select(destination interface{}) error {
 	}
 	q.destination = dest
 
-	criteriaGroups := q.buildCriteria()
+	if err := q.validate(); err != nil {
+		return err
+	}
+
+	criteriaGroups, err := q.buildCriteria()
+	if err != nil {
+		return err
+	}
 
-	itr := q.txn.iterator(true)
+	plan := q.plan()
+	items, err := q.scan(plan, criteriaGroups)
+	if err != nil {
+		return err
+	}
+
+	if !plan.ordered {
+		if err := q.sortResults(items); err != nil {
+			return err
+		}
+	}
+
+	if q.offset > 0 {
+		if q.offset >= len(items) {
+			items = items[:0]
+		} else {
+			items = items[q.offset:]
+		}
+	}
+
+	if q.limit > 0 && len(items) > q.limit {
+		items = items[:q.limit]
+	}
+
+	if err := q.txn.runHooks(hookAfterSelect, items); err != nil {
+		return err
+	}
+
+	return q.scanResults(items)
+}
+
+// scan reads the records for the plan and returns the ones that meet the criteria of the query.
+// If the plan reads the records in the order of the query then the scan stops once enough records
+// have been read to satisfy the limit.
+func (q *Query) scan(plan queryPlan, criteriaGroups [][]criteriaExpression) ([]reflect.Value, error) {
 	items := make([]reflect.Value, 0)
 	reader := newDatumReader(q.model)
-	prefix := newDatumBuilder(q.model, q.destination, false).DatumPrefix()
-	for itr.Seek(prefix); itr.ValidForPrefix(prefix); itr.Next() {
-		item := itr.Item()
-		key, value, err := make([]byte, 0), make([]byte, 0), error(nil)
-		key = item.KeyCopy(key)
-		value, err = item.ValueCopy(value)
+	datumPrefix := newDatumBuilder(q.model, q.destination, false).DatumPrefix()
+
+	readDatum := func(key, value []byte) (bool, error) {
+		result, err := reader.Read(key, value)
 		if err != nil {
-			return err
+			return false, err
 		}
 
-		if result, err := reader.Read(key, value); err != nil {
-			return err
-		} else if q.meetsCriteria(result, criteriaGroups) {
+		if (q.withDeleted || !isDeleted(q.model, result)) && q.meetsCriteria(result, criteriaGroups) {
 			items = append(items, result)
 		}
+
+		return !q.isLimitMet(plan, len(items)), nil
 	}
 
-	return q.scanResults(items)
+	// Index entries and unique keys store the primary key of the datum they point to as their
+	// value.
+	readReference := func(key, value []byte) (bool, error) {
+		datumKey := append(append(make([]byte, 0, len(datumPrefix)+len(value)), datumPrefix...), value...)
+		datum, ok, err := q.txn.tx.Get(datumKey)
+		if err != nil {
+			return false, err
+		} else if !ok {
+			return false, fmt.Errorf("key [%s] references a datum that does not exist", Key(key))
+		}
+
+		return readDatum(datumKey, datum)
+	}
+
+	switch plan.path {
+	case AccessPathPrimaryKeyLookup, AccessPathUniqueLookup:
+		read := readDatum
+		if plan.path == AccessPathUniqueLookup {
+			read = readReference
+		}
+
+		for _, key := range plan.lookups {
+			value, ok, err := q.txn.tx.Get(key)
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+
+			if next, err := read(key, value); err != nil {
+				return nil, err
+			} else if !next {
+				break
+			}
+		}
+	case AccessPathIndexScan:
+		if err := q.scanKeys(plan, false, readReference); err != nil {
+			return nil, err
+		}
+	default:
+		if err := q.scanKeys(plan, false, readDatum); err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
 }
 
-func (q *Query) buildCriteria() [][]criteriaExpression {
+// scanKeys calls the callback for every key in the plan's range, in the direction of the plan,
+// until the callback returns false. When keyOnly is true the values are not read and the callback
+// is given a nil value.
+func (q *Query) scanKeys(plan queryPlan, keyOnly bool, callback func(key, value []byte) (bool, error)) error {
+	keys := plan.keys
+
+	// When reading in reverse the seek will land on the last key that is less than or equal to
+	// the seek key. The end of the range is exclusive so that key might need to be skipped.
+	itr := q.txn.iterator(true, plan.reverse, keyOnly)
+	seek := keys.start
+	if plan.reverse {
+		seek = keys.end
+		if seek == nil {
+			seek = prefixEnd(keys.prefix)
+		}
+	} else if seek == nil {
+		seek = keys.prefix
+	}
+
+	for itr.Seek(seek); itr.ValidForPrefix(keys.prefix); itr.Next() {
+		item := itr.Item()
+		key := item.KeyCopy(make([]byte, 0))
+		if plan.reverse {
+			if keys.end != nil && bytes.Compare(key, keys.end) >= 0 {
+				continue
+			}
+
+			if keys.start != nil && bytes.Compare(key, keys.start) < 0 {
+				break
+			}
+		} else if keys.end != nil && bytes.Compare(key, keys.end) >= 0 {
+			break
+		}
+
+		var value []byte
+		if !keyOnly {
+			var err error
+			if value, err = item.ValueCopy(make([]byte, 0)); err != nil {
+				return err
+			}
+		}
+
+		if next, err := callback(key, value); err != nil {
+			return err
+		} else if !next {
+			break
+		}
+	}
+
+	return nil
+}
+
+// isLimitMet returns true if the plan reads records in the order of the query and enough records
+// have been found to satisfy the offset and limit of the query.
+func (q *Query) isLimitMet(plan queryPlan, count int) bool {
+	return plan.ordered && q.limit > 0 && count >= q.offset+q.limit
+}
+
+// validate makes sure that the model of the query is valid and that every field the query is
+// ordered by is a field of the model.
+func (q *Query) validate() error {
+	if q.err != nil {
+		return q.err
+	}
+
+	for _, order := range q.orderBy {
+		if q.model.Fields().GetByName(order.field) == nil {
+			return fmt.Errorf("cannot order by [%s], it is not a field of %s", order.field, q.model.Name())
+		}
+	}
+
+	return nil
+}
+
+// sortResults sorts the records in memory by the order of the query. Values are compared using
+// their key encoding so they sort the same way they would if they were read from an index.
+func (q *Query) sortResults(items []reflect.Value) error {
+	if len(q.orderBy) == 0 {
+		return nil
+	}
+
+	sortKeys := make([][][]byte, len(items))
+	for i, item := range items {
+		sortKeys[i] = make([][]byte, len(q.orderBy))
+		for j, order := range q.orderBy {
+			field := q.model.Fields().GetByName(order.field)
+			codec, err := getFieldCodec(field)
+			if err != nil {
+				return err
+			} else if codec == nil {
+				return fmt.Errorf("cannot order by [%s], it is a relation", order.field)
+			}
+
+			buf := buffers.NewBytesBuffer()
+			if err := codec.AppendKey(buf, item.FieldByIndex(field.Reflection().Index)); err != nil {
+				return err
+			}
+			sortKeys[i][j] = buf.Bytes()
+		}
+	}
+
+	indexes := make([]int, len(items))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	sort.SliceStable(indexes, func(a, b int) bool {
+		for j, order := range q.orderBy {
+			comparison := bytes.Compare(sortKeys[indexes[a]][j], sortKeys[indexes[b]][j])
+			if order.descending {
+				comparison = -comparison
+			}
+
+			if comparison != 0 {
+				return comparison < 0
+			}
+		}
+
+		return false
+	})
+
+	sorted := make([]reflect.Value, len(items))
+	for i, index := range indexes {
+		sorted[i] = items[index]
+	}
+	copy(items, sorted)
+
+	return nil
+}
+
+func (q *Query) buildCriteria() ([][]criteriaExpression, error) {
 	criteriaGroups := make([][]criteriaExpression, 0)
 	for _, filter := range q.filters {
 		criteriaGroup := make([]criteriaExpression, 0)
 		for fieldName, value := range filter {
 			fieldParts := strings.Split(fieldName, ".")
+			field := q.model.Fields().GetByName(fieldParts[0])
+			if field == nil {
+				return nil, fmt.Errorf("cannot filter by [%s], it is not a field of %s", fieldName, q.model.Name())
+			}
+
+			predicate := newCondition(value).predicate()
 			switch len(fieldParts) {
 			case 1:
-				switch reflect.TypeOf(value).Kind() {
-				case reflect.Slice, reflect.Array:
-					inMap := map[string]interface{}{}
-
-					valueReflection := reflect.ValueOf(value)
-					size := valueReflection.Len()
-					for i := 0; i < size; i++ {
-						inMap[fmt.Sprint(valueReflection.Index(i).Interface())] = nil
-					}
-
-					criteriaGroup = append(criteriaGroup, func(datum reflect.Value) bool {
-						field := q.model.Fields().GetByName(fieldParts[0])
-
-						datumValue := fmt.Sprint(datum.FieldByIndex(field.Reflection().Index).Interface())
-						_, ok := inMap[datumValue]
-						return ok
-					})
-				default:
-					filterValue := fmt.Sprint(value)
-					criteriaGroup = append(criteriaGroup, func(datum reflect.Value) bool {
-						field := q.model.Fields().GetByName(fieldParts[0])
-
-						// TODO (elliotcourant) build a better comparision system.
-						datumValue := fmt.Sprint(datum.FieldByIndex(field.Reflection().Index).Interface())
-						return filterValue == datumValue
-					})
+				criteriaGroup = append(criteriaGroup, func(datum reflect.Value) bool {
+					return predicate(datum.FieldByIndex(field.Reflection().Index))
+				})
+			default:
+				if field.(*modelField).isRelation {
+					return nil, fmt.Errorf("cannot filter by [%s], filtering by related models is not implemented", fieldName)
 				}
 
-			default:
-				panic("indirect fields not implemented")
+				if err := checkNestedPath(field.Reflection().Type, fieldParts[1:]); err != nil {
+					return nil, fmt.Errorf("cannot filter by [%s], %v", fieldName, err)
+				}
+
+				// If the field is not a relation then the rest of the path is used to find a value
+				// within a nested struct, map or slice.
+				criteriaGroup = append(criteriaGroup, func(datum reflect.Value) bool {
+					return predicate(getNestedValue(datum.FieldByIndex(field.Reflection().Index), fieldParts[1:]))
+				})
 			}
 		}
 		criteriaGroups = append(criteriaGroups, criteriaGroup)
 	}
 
-	return criteriaGroups
+	return criteriaGroups, nil
 }
 
 func (q *Query) meetsCriteria(item reflect.Value, criteria [][]criteriaExpression) bool {
@@ -169,3 +424,112 @@ func (q *Query) scanResults(items []reflect.Value) error {
 
 	return nil
 }
+
+// getNestedValue walks the provided path through structs, maps, slices and arrays. Struct fields
+// are found by name, map entries by their key and slice items by their index. If the path cannot
+// be followed then an invalid value is returned, which is treated as null.
+func getNestedValue(value reflect.Value, path []string) reflect.Value {
+	for _, part := range path {
+		for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+			if value.IsNil() {
+				return reflect.Value{}
+			}
+			value = value.Elem()
+		}
+
+		switch value.Kind() {
+		case reflect.Struct:
+			// Unexported fields cannot be read, so they are treated the same as a missing field.
+			structField, ok := value.Type().FieldByName(part)
+			if !ok || structField.PkgPath != "" {
+				return reflect.Value{}
+			}
+			value = value.FieldByIndex(structField.Index)
+		case reflect.Map:
+			key, err := parsePathPart(part, value.Type().Key())
+			if err != nil {
+				return reflect.Value{}
+			}
+			value = value.MapIndex(key)
+		case reflect.Slice, reflect.Array:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= value.Len() {
+				return reflect.Value{}
+			}
+			value = value.Index(index)
+		default:
+			return reflect.Value{}
+		}
+
+		if !value.IsValid() {
+			return value
+		}
+	}
+
+	return value
+}
+
+// checkNestedPath verifies that the path can be followed through the provided type. Struct fields
+// must exist and be exported, and map keys must be valid for the key type of the map. Values within
+// an interface cannot be known ahead of time, so the rest of the path is not checked.
+func checkNestedPath(typ reflect.Type, path []string) error {
+	for _, part := range path {
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+
+		switch typ.Kind() {
+		case reflect.Struct:
+			structField, ok := typ.FieldByName(part)
+			if !ok || structField.PkgPath != "" {
+				return fmt.Errorf("[%s] is not a field of %s", part, typ.Name())
+			}
+			typ = structField.Type
+		case reflect.Map:
+			if _, err := parsePathPart(part, typ.Key()); err != nil {
+				return err
+			}
+			typ = typ.Elem()
+		case reflect.Slice, reflect.Array:
+			if _, err := strconv.Atoi(part); err != nil {
+				return fmt.Errorf("[%s] is not an index of %s", part, typ)
+			}
+			typ = typ.Elem()
+		case reflect.Interface:
+			return nil
+		default:
+			return fmt.Errorf("[%s] cannot be found in %s", part, typ)
+		}
+	}
+
+	return nil
+}
+
+// parsePathPart converts part of a nested field path into a value of the provided type so that it
+// can be used as a map key.
+func parsePathPart(part string, typ reflect.Type) (reflect.Value, error) {
+	switch typ.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(part).Convert(typ), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, err := strconv.ParseInt(part, 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(number).Convert(typ), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, err := strconv.ParseUint(part, 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(number).Convert(typ), nil
+	case reflect.Bool:
+		boolean, err := strconv.ParseBool(part)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(boolean).Convert(typ), nil
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot use %s as a path to %s", part, typ)
+	}
+}
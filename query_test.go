@@ -1,8 +1,12 @@
 package mellivora
 
 import (
+	"database/sql"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestQuery_Where(t *testing.T) {
@@ -250,3 +254,501 @@ func TestQuery_AndWhere(t *testing.T) {
 		}, result)
 	})
 }
+
+func TestQuery_IsNull(t *testing.T) {
+	type Item struct {
+		ItemId   uint64 `m:"pk"`
+		Name     *string
+		Quantity sql.NullInt64
+	}
+
+	name := "Item One"
+	items := []Item{
+		{
+			ItemId:   1,
+			Name:     &name,
+			Quantity: sql.NullInt64{Int64: 10, Valid: true},
+		},
+		{
+			ItemId: 2,
+		},
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	err = txn.Insert(items)
+	assert.NoError(t, err)
+
+	t.Run("is null", func(t *testing.T) {
+		result := make([]Item, 0)
+		err = txn.Model(result).Where(Ex{
+			"Name": IsNull(),
+		}).Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{items[1]}, result)
+	})
+
+	t.Run("nil is null", func(t *testing.T) {
+		result := make([]Item, 0)
+		err = txn.Model(result).Where(Ex{
+			"Quantity": nil,
+		}).Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{items[1]}, result)
+	})
+
+	t.Run("is not null", func(t *testing.T) {
+		result := make([]Item, 0)
+		err = txn.Model(result).Where(Ex{
+			"Name": IsNotNull(),
+		}).Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{items[0]}, result)
+	})
+
+	t.Run("equal through pointer", func(t *testing.T) {
+		result := make([]Item, 0)
+		err = txn.Model(result).Where(Ex{
+			"Name":     "Item One",
+			"Quantity": 10,
+		}).Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{items[0]}, result)
+	})
+}
+
+func TestQuery_NestedFields(t *testing.T) {
+	type Address struct {
+		City string
+		zip  string
+	}
+
+	type Item struct {
+		ItemId  uint64 `m:"pk"`
+		Tags    []string
+		Labels  map[string]string
+		Address *Address
+	}
+
+	items := []Item{
+		{
+			ItemId: 1,
+			Tags:   []string{"red", "large"},
+			Labels: map[string]string{
+				"env": "prod",
+			},
+			Address: &Address{
+				City: "Springfield",
+			},
+		},
+		{
+			ItemId: 2,
+			Tags:   []string{"blue"},
+			Labels: map[string]string{
+				"env": "staging",
+			},
+		},
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	err = txn.Insert(items)
+	assert.NoError(t, err)
+
+	t.Run("map key", func(t *testing.T) {
+		result := make([]Item, 0)
+		err = txn.Model(result).Where(Ex{
+			"Labels.env": "prod",
+		}).Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{items[0]}, result)
+	})
+
+	t.Run("struct field", func(t *testing.T) {
+		result := make([]Item, 0)
+		err = txn.Model(result).Where(Ex{
+			"Address.City": "Springfield",
+		}).Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{items[0]}, result)
+	})
+
+	t.Run("slice index", func(t *testing.T) {
+		result := make([]Item, 0)
+		err = txn.Model(result).Where(Ex{
+			"Tags.0": "blue",
+		}).Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{items[1]}, result)
+	})
+
+	t.Run("missing path is null", func(t *testing.T) {
+		result := make([]Item, 0)
+		err = txn.Model(result).Where(Ex{
+			"Address.City": IsNull(),
+		}).Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []Item{items[1]}, result)
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		result := make([]Item, 0)
+		err = txn.Model(result).Where(Ex{
+			"Address.zip": "x",
+		}).Select(&result)
+		assert.EqualError(t, err, "cannot filter by [Address.zip], [zip] is not a field of Address")
+		assert.False(t, getNestedValue(reflect.ValueOf(Address{zip: "x"}), []string{"zip"}).IsValid())
+
+		err = txn.Model(result).Where(Ex{
+			"Address.Missing": "x",
+		}).Select(&result)
+		assert.EqualError(t, err, "cannot filter by [Address.Missing], [Missing] is not a field of Address")
+
+		err = txn.Model(result).Where(Ex{
+			"Tags.first": "x",
+		}).Select(&result)
+		assert.Error(t, err)
+	})
+}
+
+func TestQuery_PrimaryKeyOrder(t *testing.T) {
+	type Item struct {
+		ItemId int64 `m:"pk"`
+		Name   string
+	}
+
+	items := []Item{
+		{
+			ItemId: 300,
+			Name:   "Item Three Hundred",
+		},
+		{
+			ItemId: -20,
+			Name:   "Item Negative Twenty",
+		},
+		{
+			ItemId: 5,
+			Name:   "Item Five",
+		},
+		{
+			ItemId: -1,
+			Name:   "Item Negative One",
+		},
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	err = txn.Insert(items)
+	assert.NoError(t, err)
+
+	result := make([]Item, 0)
+	err = txn.Model(result).Select(&result)
+	assert.NoError(t, err)
+
+	ids := make([]int64, 0, len(result))
+	for _, item := range result {
+		ids = append(ids, item.ItemId)
+	}
+	assert.Equal(t, []int64{-20, -1, 5, 300}, ids, "datums should be stored in primary key order")
+}
+
+func TestQuery_Range(t *testing.T) {
+	type Item struct {
+		ItemId   int64 `m:"pk"`
+		Category string
+		Priority int32 `m:"index"`
+	}
+
+	items := make([]Item, 0, 20)
+	for i := int64(1); i <= 20; i++ {
+		items = append(items, Item{
+			ItemId:   i * 10,
+			Category: []string{"a", "b"}[i%2],
+			Priority: int32(20 - i),
+		})
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	err = txn.Insert(items)
+	assert.NoError(t, err)
+
+	idsOf := func(result []Item) []int64 {
+		ids := make([]int64, 0, len(result))
+		for _, item := range result {
+			ids = append(ids, item.ItemId)
+		}
+		return ids
+	}
+
+	t.Run("between primary key", func(t *testing.T) {
+		result := make([]Item, 0)
+		query := txn.Model(result).Where(Ex{
+			"ItemId": Between(100, 150),
+		})
+		plan := query.plan()
+		assert.Equal(t, AccessPathDatumScan, plan.path)
+		assert.NotNil(t, plan.keys.start)
+		assert.NotNil(t, plan.keys.end)
+
+		err := query.Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{100, 110, 120, 130, 140, 150}, idsOf(result))
+	})
+
+	t.Run("greater than primary key descending", func(t *testing.T) {
+		result := make([]Item, 0)
+		query := txn.Model(result).Where(Ex{
+			"ItemId": Gt(170),
+		}).OrderBy("ItemId DESC")
+		plan := query.plan()
+		assert.True(t, plan.reverse)
+		assert.True(t, plan.ordered)
+
+		err := query.Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{200, 190, 180}, idsOf(result))
+	})
+
+	t.Run("less than or equal primary key descending", func(t *testing.T) {
+		result := make([]Item, 0)
+		err := txn.Model(result).Where(Ex{
+			"ItemId": Lte(30),
+		}).OrderBy("ItemId DESC").Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{30, 20, 10}, idsOf(result))
+	})
+
+	t.Run("range on index", func(t *testing.T) {
+		result := make([]Item, 0)
+		query := txn.Model(result).Where(Ex{
+			"Priority": Lt(3),
+		})
+		plan := query.plan()
+		assert.Equal(t, AccessPathIndexScan, plan.path)
+		assert.Equal(t, "ix_priority", plan.index.Name())
+
+		err := query.Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{200, 190, 180}, idsOf(result), "results should be in index order")
+	})
+
+	t.Run("range on index with residual filter", func(t *testing.T) {
+		result := make([]Item, 0)
+		err := txn.Model(result).Where(Ex{
+			"Priority": Gte(15),
+			"Category": "a",
+		}).OrderBy("Priority DESC").Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{20, 40}, idsOf(result))
+	})
+
+	t.Run("order by unindexed field", func(t *testing.T) {
+		result := make([]Item, 0)
+		query := txn.Model(result).Where(Ex{
+			"ItemId": Lte(40),
+		}).OrderBy("Category", "ItemId DESC")
+		assert.False(t, query.plan().ordered)
+
+		err := query.Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{40, 20, 30, 10}, idsOf(result))
+	})
+
+	t.Run("limit and offset", func(t *testing.T) {
+		result := make([]Item, 0)
+		err := txn.Model(result).OrderBy("ItemId DESC").Offset(2).Limit(3).Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{180, 170, 160}, idsOf(result))
+	})
+
+	t.Run("unknown order field", func(t *testing.T) {
+		result := make([]Item, 0)
+		err := txn.Model(result).OrderBy("Missing").Select(&result)
+		assert.Error(t, err)
+	})
+}
+
+func TestQuery_UnknownFields(t *testing.T) {
+	type Item struct {
+		ItemId uint64 `m:"pk"`
+		Name   string
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	err = txn.Insert(Item{ItemId: 1, Name: "one"})
+	assert.NoError(t, err)
+
+	t.Run("filter", func(t *testing.T) {
+		result := make([]Item, 0)
+		err := txn.Model(result).Where(Ex{
+			"Missing": 1,
+		}).Select(&result)
+		assert.EqualError(t, err, "cannot filter by [Missing], it is not a field of Item")
+
+		_, err = txn.Model(result).Where(Ex{
+			"Missing.Nested": 1,
+		}).Explain()
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid model", func(t *testing.T) {
+		result := make([]int, 0)
+		err := txn.Model(result).Select(&result)
+		assert.Error(t, err)
+
+		err = txn.Insert(1)
+		assert.Error(t, err)
+	})
+}
+
+func TestQuery_EmbeddedFields(t *testing.T) {
+	type Timestamps struct {
+		CreatedAt time.Time `m:"index"`
+	}
+
+	type Item struct {
+		ItemId uint64 `m:"pk"`
+		Timestamps
+		Name string
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	start := time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC)
+	items := make([]Item, 0, 5)
+	for i := 0; i < 5; i++ {
+		items = append(items, Item{
+			ItemId: uint64(i + 1),
+			Timestamps: Timestamps{
+				CreatedAt: start.Add(time.Duration(i) * time.Hour),
+			},
+			Name: fmt.Sprintf("Item %d", i+1),
+		})
+	}
+
+	err = txn.Insert(items)
+	assert.NoError(t, err)
+
+	result := make([]Item, 0)
+	err = txn.Model(result).Where(Ex{
+		"CreatedAt": Gte(start.Add(3 * time.Hour)),
+	}).Select(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, items[3:], result)
+}
+
+func TestQuery_TimeEquality(t *testing.T) {
+	type Event struct {
+		EventId uint64 `m:"pk"`
+		At      time.Time
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	// The filter has a monotonic clock reading and a local time zone, the stored time does not.
+	now := time.Now()
+	err = txn.Insert([]Event{
+		{EventId: 1, At: now},
+		{EventId: 2, At: now.Add(time.Second)},
+	})
+	assert.NoError(t, err)
+
+	for name, filter := range map[string]interface{}{
+		"equal": now,
+		"in":    []time.Time{now, now.Add(time.Hour)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			result := make([]Event, 0)
+			err := txn.Model(result).Where(Ex{
+				"At": filter,
+			}).Select(&result)
+			assert.NoError(t, err)
+			if assert.Len(t, result, 1) {
+				assert.Equal(t, uint64(1), result[0].EventId)
+			}
+		})
+	}
+}
+
+func TestQuery_LossyNumbers(t *testing.T) {
+	type Listener struct {
+		ListenerId uint64 `m:"pk"`
+		Port       int16
+		Count      int
+		Weight     uint8
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	err = txn.Insert([]Listener{
+		{ListenerId: 1, Port: 4464, Count: 1, Weight: 0},
+		{ListenerId: 2, Port: -1, Count: 2, Weight: 255},
+	})
+	assert.NoError(t, err)
+
+	// None of these values can be converted to the type of the field without changing them, 70000
+	// would become 4464 and 1.9 would become 1 if they were converted.
+	for name, test := range map[string]struct {
+		filter   Ex
+		expected []uint64
+	}{
+		"equal out of range":        {Ex{"Port": 70000}, []uint64{}},
+		"equal fraction":            {Ex{"Count": 1.9}, []uint64{}},
+		"equal negative unsigned":   {Ex{"Weight": -256}, []uint64{}},
+		"in out of range":           {Ex{"Port": []int{70000, -1}}, []uint64{2}},
+		"in fraction":               {Ex{"Count": []float64{1.9, 2}}, []uint64{2}},
+		"greater than fraction":     {Ex{"Count": Gt(1.5)}, []uint64{2}},
+		"less than fraction":        {Ex{"Count": Lte(1.5)}, []uint64{1}},
+		"greater than negative":     {Ex{"Weight": Gt(-1)}, []uint64{1, 2}},
+		"less than out of range":    {Ex{"Port": Lt(70000)}, []uint64{1, 2}},
+		"greater than out of range": {Ex{"Port": Gte(70000)}, []uint64{}},
+		"between out of range":      {Ex{"Weight": Between(-10, 1000)}, []uint64{1, 2}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			result := make([]Listener, 0)
+			err := txn.Model(result).Where(test.filter).OrderBy("ListenerId").Select(&result)
+			assert.NoError(t, err)
+			ids := make([]uint64, len(result))
+			for i, listener := range result {
+				ids[i] = listener.ListenerId
+			}
+			assert.Equal(t, test.expected, ids)
+		})
+	}
+}
@@ -1,27 +1,59 @@
 package mellivora
 
 import (
+	"errors"
 	"fmt"
+	"github.com/dgraph-io/badger"
 	"github.com/elliotcourant/meles"
 	"reflect"
+	"sort"
+	"time"
 )
 
 type Transaction struct {
 	db  *Database
 	tx  *meles.Transaction
 	itr *meles.Iterator
+
+	itrReverse bool
+	itrKeyOnly bool
+
+	// readOnly transactions reject writes, and are discarded rather than committed.
+	readOnly bool
 }
 
+// Model starts a query for the provided model. If the model is not valid then the error will be
+// returned when the query is executed.
 func (txn *Transaction) Model(model interface{}) *Query {
+	info, err := txn.db.getModel(model)
 	return &Query{
-		model: getModelInfo(model),
+		model: info,
 		txn:   txn,
+		err:   err,
 	}
 }
 
+// Commit writes the changes made by the transaction. If another transaction changed any of the
+// keys read or written by this transaction then ErrConflict is returned. When this node is not the
+// leader the commit is forwarded to the leader, which only returns the message of its error, so
+// those conflicts are returned as the store's error instead. Committing a read only transaction
+// only releases it, since it has nothing to write and cannot conflict.
 func (txn *Transaction) Commit() error {
 	txn.disposeIterator()
-	return txn.tx.Commit()
+	if txn.readOnly {
+		return txn.tx.Rollback()
+	}
+
+	if err := txn.tx.Commit(); err != nil {
+		// The store returns the conflict error of badger when the commit is applied by this node.
+		if errors.Is(err, badger.ErrConflict) {
+			return ErrConflict
+		}
+
+		return err
+	}
+
+	return nil
 }
 
 func (txn *Transaction) Rollback() error {
@@ -29,46 +61,582 @@ func (txn *Transaction) Rollback() error {
 	return txn.tx.Rollback()
 }
 
+// ReadOnly returns true if the transaction was started with BeginReadOnly or BeginReadOnlyAt.
+func (txn *Transaction) ReadOnly() bool {
+	return txn.readOnly
+}
+
 func (txn *Transaction) Insert(model interface{}) error {
-	info := getModelInfo(model)
-	builder := newDatumBuilder(info, reflect.ValueOf(model), true)
+	pending, err := txn.prepareInsert(model)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.writeInsert(pending); err != nil {
+		return err
+	}
+
+	return txn.runHooks(hookAfterInsert, pending.records)
+}
+
+// pendingInsert holds records that have been checked by prepareInsert and the keys that will be
+// written for them.
+type pendingInsert struct {
+	records []reflect.Value
+	datums  map[string][]byte
+}
+
+// prepareInsert runs the BeforeInsert hooks of the records and makes sure that they can be
+// inserted. The records are validated and checked and their keys are built and verified against
+// the keys that are already stored. Nothing is written, so if an error is returned the
+// transaction can still be used to insert other records.
+func (txn *Transaction) prepareInsert(model interface{}) (*pendingInsert, error) {
+	info, err := txn.db.getModel(model)
+	if err != nil {
+		return nil, err
+	}
+
+	if txn.readOnly {
+		return nil, fmt.Errorf("%w: cannot insert %s", ErrReadOnly, info.Name())
+	}
+
+	value, records := getRecords(model)
+	if err := txn.runHooks(hookBeforeInsert, records); err != nil {
+		return nil, err
+	}
+
+	// Defaults are applied before the records are validated, since they are part of what will be
+	// stored.
+	for i, record := range records {
+		if err := validateRecord(info, applyDefaults(info, record)); err != nil {
+			return nil, rowError(value, i, err)
+		}
+
+		if err := txn.db.checkRecord(info, record); err != nil {
+			return nil, rowError(value, i, err)
+		}
+	}
+
+	builder := newDatumBuilder(info, value, true)
 
 	datums, err := builder.Keys()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	verify, err := builder.Verify()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Records can reference other records that are inserted with them.
+	found := map[string]bool{}
+	for key := range datums {
+		if key[0] == datumKeyPrefix {
+			found[key] = true
+		}
+	}
+
+	for i, record := range records {
+		if err := txn.checkForeignKeys(info, record, reflect.Value{}, found); err != nil {
+			return nil, rowError(value, i, err)
+		}
+	}
+
+	// Keys are checked and written in order so that inserts are deterministic, and so that the
+	// store receives sorted writes.
+	verifyKeys := make([]string, 0, len(verify))
+	for verifyKey := range verify {
+		verifyKeys = append(verifyKeys, verifyKey)
 	}
+	sort.Strings(verifyKeys)
 
-	for verifyKey, canExist := range verify {
+	for _, verifyKey := range verifyKeys {
 		_, ok, err := txn.tx.MustGet([]byte(verifyKey))
 		if err != nil {
+			return nil, err
+		}
+		if ok && !verify[verifyKey] {
+			return nil, keyViolation(info, []byte(verifyKey))
+		}
+	}
+
+	return &pendingInsert{
+		records: records,
+		datums:  datums,
+	}, nil
+}
+
+// writeInsert writes the keys of records that were checked by prepareInsert. Any error is from the
+// store, the AfterInsert hooks of the records are run separately.
+func (txn *Transaction) writeInsert(pending *pendingInsert) error {
+	for _, key := range sortedKeys(pending.datums) {
+		if err := txn.tx.Set([]byte(key), pending.datums[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Update replaces the stored records that have the same primary keys as the provided model, which
+// can be a single record or a slice of records. Fields tagged with readonly keep their stored
+// value. Unique constraints and indexes are updated to reflect the new values. Every record is
+// validated and checked before any of them are written. If the model has a field tagged with
+// version then it must match the stored version, and is incremented.
+func (txn *Transaction) Update(model interface{}) error {
+	return txn.update(model, false)
+}
+
+// UpdateWithDeleted is like Update but also updates records that have been soft deleted, which
+// Update treats as not found. The deleted_at field of the records is not changed.
+func (txn *Transaction) UpdateWithDeleted(model interface{}) error {
+	return txn.update(model, true)
+}
+
+func (txn *Transaction) update(model interface{}, withDeleted bool) error {
+	info, err := txn.db.getModel(model)
+	if err != nil {
+		return err
+	}
+
+	if txn.readOnly {
+		return fmt.Errorf("%w: cannot update %s", ErrReadOnly, info.Name())
+	}
+
+	value, records := getRecords(model)
+	if err := txn.runHooks(hookBeforeUpdate, records); err != nil {
+		return err
+	}
+
+	// Records are validated and checked as they will be stored, with the fields that keep their
+	// stored values.
+	updates, found := make([]*pendingUpdate, len(records)), map[string]bool{}
+	for i, record := range records {
+		if updates[i], err = txn.prepareUpdate(info, record, withDeleted); err != nil {
+			return err
+		}
+
+		if err := validateRecord(info, updates[i].merged); err != nil {
+			return rowError(value, i, err)
+		}
+
+		if err := txn.db.checkRecord(info, updates[i].merged); err != nil {
+			return rowError(value, i, err)
+		}
+
+		if err := txn.checkForeignKeys(info, updates[i].merged, updates[i].existing, found); err != nil {
+			return rowError(value, i, err)
+		}
+	}
+
+	// Every key is verified before anything is written so that a violation does not leave some of
+	// the records updated.
+	if err := txn.verifyUpdates(info, updates); err != nil {
+		return err
+	}
+
+	if err := txn.writeUpdates(updates); err != nil {
+		return err
+	}
+
+	return txn.runHooks(hookAfterUpdate, records)
+}
+
+// pendingUpdate holds a record as it will be stored and the keys of the record before and after
+// the update. The record is replaced by the merged record once it is written, soft deletes do not
+// have one.
+type pendingUpdate struct {
+	record    reflect.Value
+	merged    reflect.Value
+	existing  reflect.Value
+	oldDatums map[string][]byte
+	newDatums map[string][]byte
+}
+
+// prepareUpdate reads the stored record and merges the fields that keep their stored values into
+// a copy of the record, then builds the keys of both. Nothing is written.
+func (txn *Transaction) prepareUpdate(info Model, value reflect.Value, withDeleted bool) (*pendingUpdate, error) {
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	datumKey, existing, err := txn.getExisting(info, value)
+	if err != nil {
+		return nil, err
+	}
+
+	if !withDeleted && isDeleted(info, existing) {
+		return nil, fmt.Errorf("%w: %s with primary key (%s) is deleted",
+			ErrNotFound, info.Name(), formatKeyValues(primaryKeyValues(info, value)))
+	}
+
+	merged := reflect.New(value.Type()).Elem()
+	merged.Set(value)
+
+	if version := info.(*modelInfo).version; version != nil {
+		if err := checkVersion(info, datumKey, value, existing); err != nil {
+			return nil, err
+		}
+
+		setField(merged, version, versionValue(version, getVersion(version, existing)+1))
+	}
+
+	// The creation and deletion times of a record are only changed by inserting or deleting it.
+	timestamps := info.(*modelInfo)
+	for _, field := range info.Fields().GetAll() {
+		if field.(*modelField).isReadOnly || field == timestamps.createdAt || field == timestamps.deletedAt {
+			setField(merged, field, existing.FieldByIndex(field.Reflection().Index))
+		}
+	}
+
+	if timestamps.updatedAt != nil {
+		setField(merged, timestamps.updatedAt, timestampValue(timestamps.updatedAt, time.Now()))
+	}
+
+	oldDatums, err := newDatumBuilder(info, existing, false).Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	newDatums, err := newDatumBuilder(info, merged, false).Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pendingUpdate{
+		record:    value,
+		merged:    merged,
+		existing:  existing,
+		oldDatums: oldDatums,
+		newDatums: newDatums,
+	}, nil
+}
+
+// verifyUpdates makes sure that the updated records can be written. A primary or unique key can
+// only be written for one of the records, and a unique key that none of the records owned before
+// the update must not belong to any other record. A unique key can move from one record to another
+// within the same update.
+func (txn *Transaction) verifyUpdates(info Model, updates []*pendingUpdate) error {
+	released := map[string]bool{}
+	for _, update := range updates {
+		for key := range update.oldDatums {
+			if key[0] == uniqueKeyPrefix {
+				released[key] = true
+			}
+		}
+	}
+
+	claimed := map[string]bool{}
+	for _, update := range updates {
+		for _, key := range sortedKeys(update.newDatums) {
+			if key[0] != datumKeyPrefix && key[0] != uniqueKeyPrefix {
+				continue
+			}
+
+			if claimed[key] {
+				return keyViolation(info, []byte(key))
+			}
+			claimed[key] = true
+
+			if key[0] != uniqueKeyPrefix || released[key] {
+				continue
+			}
+
+			_, exists, err := txn.tx.MustGet([]byte(key))
+			if err != nil {
+				return err
+			} else if exists {
+				return keyViolation(info, []byte(key))
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeUpdates deletes the keys that the records no longer have and then writes their new keys.
+// Every record's keys are deleted first so that a unique key moving between records is not
+// deleted after it is written.
+func (txn *Transaction) writeUpdates(updates []*pendingUpdate) error {
+	for _, update := range updates {
+		for _, key := range sortedKeys(update.oldDatums) {
+			if _, ok := update.newDatums[key]; ok {
+				continue
+			}
+
+			if err := txn.tx.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, update := range updates {
+		for _, key := range sortedKeys(update.newDatums) {
+			if err := txn.tx.Set([]byte(key), update.newDatums[key]); err != nil {
+				return err
+			}
+		}
+
+		if update.record.CanSet() {
+			update.record.Set(update.merged)
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the records that have the same primary keys as the provided model, which can be
+// a single record or a slice of records. If the model has a field tagged with deleted_at then the
+// records are not removed, instead the field is set to the current time and the records will be
+// excluded from queries. Like Update, the version of each record must match its stored version.
+func (txn *Transaction) Delete(model interface{}) error {
+	info, err := txn.db.getModel(model)
+	if err != nil {
+		return err
+	}
+
+	if txn.readOnly {
+		return fmt.Errorf("%w: cannot delete %s", ErrReadOnly, info.Name())
+	}
+
+	_, records := getRecords(model)
+	if err := txn.runHooks(hookBeforeDelete, records); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if err := txn.deleteSingle(info, record); err != nil {
+			return err
+		}
+	}
+
+	return txn.runHooks(hookAfterDelete, records)
+}
+
+func (txn *Transaction) deleteSingle(info Model, value reflect.Value) error {
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	datumKey, existing, err := txn.getExisting(info, value)
+	if err != nil {
+		return err
+	}
+
+	if err := checkVersion(info, datumKey, value, existing); err != nil {
+		return err
+	}
+
+	if deletedAt := info.(*modelInfo).deletedAt; deletedAt != nil {
+		if isDeleted(info, existing) {
+			return fmt.Errorf("%w: %s with primary key (%s) is already deleted",
+				ErrNotFound, info.Name(), formatKeyValues(primaryKeyValues(info, value)))
+		}
+
+		oldDatums, err := newDatumBuilder(info, existing, false).Keys()
+		if err != nil {
+			return err
+		}
+
+		now := timestampValue(deletedAt, time.Now())
+		existing.FieldByIndex(deletedAt.Reflection().Index).Set(now)
+
+		// Soft deleting a record changes it, so the version is incremented like an update.
+		version := info.(*modelInfo).version
+		var next reflect.Value
+		if version != nil {
+			next = versionValue(version, getVersion(version, existing)+1)
+			existing.FieldByIndex(version.Reflection().Index).Set(next)
+		}
+
+		// Unique constraints and indexes can include the deletion time, so the keys of the record
+		// are replaced like an update rather than only writing the datum.
+		newDatums, err := newDatumBuilder(info, existing, false).Keys()
+		if err != nil {
+			return err
+		}
+
+		updates := []*pendingUpdate{
+			{
+				oldDatums: oldDatums,
+				newDatums: newDatums,
+			},
+		}
+		if err := txn.verifyUpdates(info, updates); err != nil {
+			return err
+		}
+
+		if err := txn.writeUpdates(updates); err != nil {
 			return err
 		}
-		if ok && !canExist {
-			return fmt.Errorf("an item with key [%s] already exists, cannot overwrite", verifyKey)
+
+		if value.CanSet() {
+			value.FieldByIndex(deletedAt.Reflection().Index).Set(now)
+			if version != nil {
+				value.FieldByIndex(version.Reflection().Index).Set(next)
+			}
 		}
+
+		return nil
+	}
+
+	datums, err := newDatumBuilder(info, existing, false).Keys()
+	if err != nil {
+		return err
 	}
 
-	for key, value := range datums {
-		if err := txn.tx.Set([]byte(key), value); err != nil {
+	for _, key := range sortedKeys(datums) {
+		if err := txn.tx.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkForeignKeys makes sure that the record referenced by each of the relations of the model
+// exists. Foreign keys that are null or the zero value of their type do not reference anything and
+// are not checked, neither are foreign keys that have the same value as the existing record when it
+// is provided. Referenced datums that are not already in found are read with MustGet so that the
+// transaction will conflict if they are deleted before this one commits, and are then added to
+// found so that they are only read once.
+func (txn *Transaction) checkForeignKeys(info Model, record, existing reflect.Value, found map[string]bool) error {
+	for _, field := range info.Fields().GetAll() {
+		foreignKey := field.(*modelField).foreignKey
+		if foreignKey == nil {
+			continue
+		}
+
+		relationType := field.Reflection().Type
+		for relationType.Kind() == reflect.Ptr {
+			relationType = relationType.Elem()
+		}
+
+		value := record.FieldByIndex(foreignKey.Reflection().Index)
+		if relationType.Kind() != reflect.Struct || isNullValue(value) || isZeroValue(value) {
+			continue
+		}
+
+		if existing.IsValid() &&
+			reflect.DeepEqual(value.Interface(), existing.FieldByIndex(foreignKey.Reflection().Index).Interface()) {
+			continue
+		}
+
+		for value.Kind() == reflect.Ptr {
+			value = value.Elem()
+		}
+
+		related, err := txn.db.getModel(reflect.Zero(relationType).Interface())
+		if err != nil {
+			return err
+		}
+
+		violation := &ForeignKeyViolation{
+			Model:      info.Name(),
+			ForeignKey: foreignKey.Name(),
+			References: related.Name(),
+			Values:     []interface{}{value.Interface()},
+		}
+
+		primaryKey := related.PrimaryKey().GetAll()
+		if len(primaryKey) != 1 {
+			return fmt.Errorf("%s field [%s] cannot reference %s, it has a composite primary key",
+				info.Name(), foreignKey.Name(), related.Name())
+		}
+
+		// A value that cannot be represented by the primary key cannot reference a record.
+		converted, err := convertFilterValue(value.Interface(), primaryKey[0].Reflection().Type)
+		var lossy *lossyConversionError
+		if errors.As(err, &lossy) {
+			return violation
+		} else if err != nil {
+			return fmt.Errorf("%s field [%s] cannot reference %s: %v",
+				info.Name(), foreignKey.Name(), related.Name(), err)
+		}
+
+		relatedRecord := reflect.New(relationType).Elem()
+		relatedRecord.FieldByIndex(primaryKey[0].Reflection().Index).Set(converted)
+		encodedKey, err := encodePrimaryKey(related, relatedRecord)
+		if err != nil {
 			return err
 		}
+
+		datumKey := append(newDatumBuilder(related, relatedRecord, false).DatumPrefix(), encodedKey...)
+		if found[string(datumKey)] {
+			continue
+		}
+
+		if _, ok, err := txn.tx.MustGet(datumKey); err != nil {
+			return err
+		} else if !ok {
+			return violation
+		}
+		found[string(datumKey)] = true
+	}
+
+	return nil
+}
+
+// checkVersion returns a *StaleVersionError if the model has a version field and the version of
+// the provided record does not match the version of the stored record.
+func checkVersion(info Model, datumKey []byte, value, existing reflect.Value) error {
+	version := info.(*modelInfo).version
+	if version == nil {
+		return nil
+	}
+
+	expected, actual := getVersion(version, value), getVersion(version, existing)
+	if expected != actual {
+		return &StaleVersionError{
+			Model:    info.Name(),
+			Key:      datumKey,
+			Expected: expected,
+			Actual:   actual,
+		}
 	}
 
 	return nil
 }
 
-func (txn *Transaction) iterator(reset bool) *meles.Iterator {
+// getExisting reads the stored record with the same primary key as the provided record. The
+// datum is read with MustGet so that the transaction will conflict if the record is changed by
+// another transaction before this one commits.
+func (txn *Transaction) getExisting(info Model, value reflect.Value) ([]byte, reflect.Value, error) {
+	primaryKey, err := encodePrimaryKey(info, value)
+	if err != nil {
+		return nil, reflect.Value{}, err
+	}
+
+	datumPrefix := newDatumBuilder(info, value, false).DatumPrefix()
+	datumKey := append(datumPrefix, primaryKey...)
+
+	existingValue, ok, err := txn.tx.MustGet(datumKey)
+	if err != nil {
+		return nil, reflect.Value{}, err
+	} else if !ok {
+		return nil, reflect.Value{}, fmt.Errorf("%w: %s with primary key (%s)",
+			ErrNotFound, info.Name(), formatKeyValues(primaryKeyValues(info, value)))
+	}
+
+	existing, err := newDatumReader(info).Read(datumKey, existingValue)
+	if err != nil {
+		return nil, reflect.Value{}, err
+	}
+
+	return datumKey, existing, nil
+}
+
+// iterator returns the transaction's iterator, creating a new one if it does not exist or does not
+// match the direction or values requested. Key only iterators do not prefetch the values of keys.
+func (txn *Transaction) iterator(reset, reverse, keyOnly bool) *meles.Iterator {
 	if txn.itr == nil {
-		txn.itr = txn.tx.GetIterator(make([]byte, 0), false, false)
-	} else if reset {
+		txn.itr = txn.tx.GetIterator(make([]byte, 0), keyOnly, reverse)
+	} else if reset || txn.itrReverse != reverse || txn.itrKeyOnly != keyOnly {
 		txn.itr.Close()
-		txn.itr = txn.tx.GetIterator(make([]byte, 0), false, false)
+		txn.itr = txn.tx.GetIterator(make([]byte, 0), keyOnly, reverse)
 	}
+	txn.itrReverse, txn.itrKeyOnly = reverse, keyOnly
 
 	return txn.itr
 }
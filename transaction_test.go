@@ -1,8 +1,11 @@
 package mellivora
 
 import (
+	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestTransaction_Insert(t *testing.T) {
@@ -167,3 +170,725 @@ func TestTransaction_Insert(t *testing.T) {
 		}
 	})
 }
+
+func TestTransaction_Update(t *testing.T) {
+	type Account struct {
+		AccountId uint64 `m:"pk"`
+		Email     string `m:"uq"`
+		Region    string `m:"index"`
+		CreatedBy string `m:"readonly"`
+		Balance   int64
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	err = txn.Insert([]Account{
+		{
+			AccountId: 1,
+			Email:     "one@example.com",
+			Region:    "east",
+			CreatedBy: "admin",
+			Balance:   100,
+		},
+		{
+			AccountId: 2,
+			Email:     "two@example.com",
+			Region:    "west",
+			CreatedBy: "admin",
+			Balance:   200,
+		},
+	})
+	assert.NoError(t, err)
+
+	t.Run("simple", func(t *testing.T) {
+		account := &Account{
+			AccountId: 1,
+			Email:     "first@example.com",
+			Region:    "west",
+			CreatedBy: "someone else",
+			Balance:   150,
+		}
+		err := txn.Update(account)
+		assert.NoError(t, err)
+		assert.Equal(t, "admin", account.CreatedBy, "read only fields should keep their stored value")
+
+		result := Account{}
+		err = txn.Model(result).Where(Ex{
+			"Email": "first@example.com",
+		}).Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, *account, result)
+
+		// The old unique value should no longer find the record.
+		result = Account{}
+		err = txn.Model(result).Where(Ex{
+			"Email": "one@example.com",
+		}).Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, Account{}, result)
+
+		// The index should have moved the record to its new region.
+		results := make([]Account, 0)
+		err = txn.Model(results).Where(Ex{
+			"Region": "west",
+		}).Select(&results)
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+
+		results = make([]Account, 0)
+		err = txn.Model(results).Where(Ex{
+			"Region": "east",
+		}).Select(&results)
+		assert.NoError(t, err)
+		assert.Empty(t, results)
+	})
+
+	t.Run("unique violation", func(t *testing.T) {
+		err := txn.Update(Account{
+			AccountId: 2,
+			Email:     "first@example.com",
+		})
+		assert.Error(t, err)
+	})
+
+	emailsOf := func(t *testing.T) map[string]uint64 {
+		results := make([]Account, 0)
+		err := txn.Model(results).Select(&results)
+		assert.NoError(t, err)
+
+		// Every record should be found by its unique value, and only by its unique value.
+		emails := map[string]uint64{}
+		for _, account := range results {
+			result := Account{}
+			err = txn.Model(result).Where(Ex{
+				"Email": account.Email,
+			}).Select(&result)
+			assert.NoError(t, err)
+			assert.Equal(t, account, result)
+			emails[account.Email] = account.AccountId
+		}
+
+		return emails
+	}
+
+	t.Run("unique violation within the update", func(t *testing.T) {
+		err := txn.Update([]Account{
+			{AccountId: 1, Email: "same@example.com", Region: "west", Balance: 150},
+			{AccountId: 2, Email: "same@example.com", Region: "west", Balance: 200},
+		})
+		var uniqueViolation *UniqueViolation
+		assert.True(t, errors.As(err, &uniqueViolation))
+
+		// Neither record should have been written.
+		assert.Equal(t, map[string]uint64{
+			"first@example.com": 1,
+			"two@example.com":   2,
+		}, emailsOf(t))
+
+		result := Account{}
+		err = txn.Model(result).Where(Ex{
+			"Email": "same@example.com",
+		}).Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, Account{}, result)
+	})
+
+	t.Run("swap unique values", func(t *testing.T) {
+		err := txn.Update([]Account{
+			{AccountId: 1, Email: "two@example.com", Region: "west", Balance: 150},
+			{AccountId: 2, Email: "first@example.com", Region: "west", Balance: 200},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]uint64{
+			"two@example.com":   1,
+			"first@example.com": 2,
+		}, emailsOf(t))
+	})
+
+	t.Run("missing record", func(t *testing.T) {
+		err := txn.Update(Account{
+			AccountId: 3,
+			Email:     "three@example.com",
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestTransaction_InsertDefaults(t *testing.T) {
+	type Job struct {
+		JobId  uint64 `m:"pk"`
+		Status string `m:"default:pending"`
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	job := &Job{JobId: 1}
+	err = txn.Insert(job)
+	assert.NoError(t, err)
+	assert.Equal(t, "pending", job.Status)
+
+	err = txn.Insert(Job{JobId: 2, Status: "running"})
+	assert.NoError(t, err)
+
+	results := make([]Job, 0)
+	err = txn.Model(results).Select(&results)
+	assert.NoError(t, err)
+	assert.Equal(t, []Job{{JobId: 1, Status: "pending"}, {JobId: 2, Status: "running"}}, results)
+}
+
+func TestTransaction_Delete(t *testing.T) {
+	type Account struct {
+		AccountId uint64 `m:"pk"`
+		Email     string `m:"uq"`
+		Region    string `m:"index"`
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	account := Account{
+		AccountId: 1,
+		Email:     "one@example.com",
+		Region:    "east",
+	}
+
+	err = txn.Insert(account)
+	assert.NoError(t, err)
+
+	err = txn.Delete(Account{AccountId: 1})
+	assert.NoError(t, err)
+
+	results := make([]Account, 0)
+	err = txn.Model(results).Where(Ex{
+		"Region": "east",
+	}).Select(&results)
+	assert.NoError(t, err)
+	assert.Empty(t, results, "the index entry should be removed")
+
+	// The unique key should have been removed as well, so the record can be inserted again.
+	err = txn.Insert(account)
+	assert.NoError(t, err)
+
+	err = txn.Delete(Account{AccountId: 2})
+	assert.Error(t, err, "records that do not exist cannot be deleted")
+}
+
+func TestTransaction_Timestamps(t *testing.T) {
+	type Account struct {
+		AccountId uint64 `m:"pk"`
+		Name      string
+		CreatedAt time.Time  `m:"created_at"`
+		UpdatedAt time.Time  `m:"updated_at"`
+		DeletedAt *time.Time `m:"deleted_at"`
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	before := time.Now()
+	accounts := []Account{
+		{AccountId: 1, Name: "one"},
+		{AccountId: 2, Name: "two"},
+	}
+	err = txn.Insert(accounts)
+	assert.NoError(t, err)
+
+	for _, account := range accounts {
+		assert.False(t, account.CreatedAt.Before(before))
+		assert.Equal(t, account.CreatedAt, account.UpdatedAt)
+		assert.Nil(t, account.DeletedAt)
+	}
+
+	t.Run("update", func(t *testing.T) {
+		account := &Account{AccountId: 1, Name: "first"}
+		err := txn.Update(account)
+		assert.NoError(t, err)
+		assert.True(t, account.CreatedAt.Equal(accounts[0].CreatedAt), "created at should not change")
+		assert.True(t, account.UpdatedAt.After(accounts[0].UpdatedAt))
+	})
+
+	t.Run("soft delete", func(t *testing.T) {
+		account := &Account{AccountId: 2}
+		err := txn.Delete(account)
+		assert.NoError(t, err)
+		assert.NotNil(t, account.DeletedAt)
+
+		results := make([]Account, 0)
+		err = txn.Model(results).Select(&results)
+		assert.NoError(t, err)
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, uint64(1), results[0].AccountId)
+		}
+
+		results = make([]Account, 0)
+		err = txn.Model(results).WithDeleted().Select(&results)
+		assert.NoError(t, err)
+		if assert.Len(t, results, 2) {
+			assert.NotNil(t, results[1].DeletedAt)
+			assert.Equal(t, "two", results[1].Name)
+		}
+
+		plan, err := txn.Model(results).Explain()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"DeletedAt IS NULL"}, plan.ResidualFilters)
+
+		err = txn.Delete(account)
+		assert.Error(t, err, "a record cannot be deleted twice")
+	})
+
+	t.Run("update deleted", func(t *testing.T) {
+		err := txn.Update(&Account{AccountId: 2, Name: "second"})
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.EqualError(t, err, "record not found: Account with primary key (2) is deleted")
+
+		account := &Account{AccountId: 2, Name: "second"}
+		err = txn.UpdateWithDeleted(account)
+		assert.NoError(t, err)
+		assert.NotNil(t, account.DeletedAt, "the record should still be deleted")
+
+		results := make([]Account, 0)
+		err = txn.Model(results).WithDeleted().Where(Ex{"AccountId": 2}).Select(&results)
+		assert.NoError(t, err)
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, "second", results[0].Name)
+			assert.NotNil(t, results[0].DeletedAt)
+		}
+	})
+}
+
+func TestTransaction_SoftDeleteKeys(t *testing.T) {
+	type Member struct {
+		MemberId  uint64     `m:"pk"`
+		Handle    string     `m:"uq:uq_handle_deleted_at"`
+		DeletedAt *time.Time `m:"deleted_at,uq:uq_handle_deleted_at,index"`
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	err = txn.Insert([]Member{
+		{MemberId: 1, Handle: "one"},
+		{MemberId: 2, Handle: "two"},
+	})
+	assert.NoError(t, err)
+
+	member := &Member{MemberId: 1}
+	err = txn.Delete(member)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, member.DeletedAt) {
+		return
+	}
+
+	t.Run("index", func(t *testing.T) {
+		query := txn.Model(Member{}).WithDeleted().Where(Ex{
+			"DeletedAt": Gte(member.DeletedAt.Add(-time.Hour)),
+		})
+		plan, err := query.Explain()
+		assert.NoError(t, err)
+		assert.Equal(t, AccessPathIndexScan, plan.AccessPath)
+		assert.Equal(t, 2, plan.EstimatedKeys, "the index entry should have the deletion time")
+
+		results := make([]Member, 0)
+		err = query.Select(&results)
+		assert.NoError(t, err)
+		if assert.Len(t, results, 1) {
+			assert.Equal(t, uint64(1), results[0].MemberId)
+		}
+	})
+
+	t.Run("unique", func(t *testing.T) {
+		query := txn.Model(Member{}).WithDeleted().Where(Ex{
+			"Handle":    "one",
+			"DeletedAt": *member.DeletedAt,
+		})
+		plan, err := query.Explain()
+		assert.NoError(t, err)
+		assert.Equal(t, AccessPathUniqueLookup, plan.AccessPath)
+
+		result := Member{}
+		err = query.Select(&result)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), result.MemberId)
+	})
+}
+
+func TestTransaction_Version(t *testing.T) {
+	type Account struct {
+		AccountId uint64 `m:"pk"`
+		Name      string
+		Version   int32 `m:"version"`
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	err = txn.Insert(Account{AccountId: 1, Name: "one"})
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Commit())
+
+	// Two read-modify-write cycles read the same version of the record.
+	first, second := Account{AccountId: 1}, Account{AccountId: 1}
+	for _, account := range []*Account{&first, &second} {
+		txn, err = db.Begin()
+		assert.NoError(t, err)
+
+		results := make([]Account, 0)
+		err = txn.Model(results).Where(Ex{"AccountId": 1}).Select(&results)
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		*account = results[0]
+		assert.NoError(t, txn.Rollback())
+	}
+
+	t.Run("update increments the version", func(t *testing.T) {
+		txn, err := db.Begin()
+		assert.NoError(t, err)
+
+		first.Name = "first"
+		err = txn.Update(&first)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), first.Version)
+		assert.NoError(t, txn.Commit())
+	})
+
+	t.Run("stale update", func(t *testing.T) {
+		txn, err := db.Begin()
+		assert.NoError(t, err)
+		defer txn.Rollback()
+
+		second.Name = "second"
+		err = txn.Update(&second)
+		assert.True(t, errors.Is(err, ErrStaleVersion))
+
+		var staleErr *StaleVersionError
+		if assert.True(t, errors.As(err, &staleErr)) {
+			assert.Equal(t, "Account", staleErr.Model)
+			assert.Equal(t, uint64(0), staleErr.Expected)
+			assert.Equal(t, uint64(1), staleErr.Actual)
+
+			// Other tests declare an Account type as well, so the key may be rendered as hex.
+			assert.EqualError(t, err, fmt.Sprintf("stale version: Account record with key [%s] is at version 1, not 0",
+				Key(staleErr.Key)))
+			assert.NotContains(t, err.Error(), string(staleErr.Key))
+		}
+
+		results := make([]Account, 0)
+		err = txn.Model(results).Select(&results)
+		assert.NoError(t, err)
+		assert.Equal(t, []Account{{AccountId: 1, Name: "first", Version: 1}}, results)
+	})
+
+	t.Run("stale delete", func(t *testing.T) {
+		txn, err := db.Begin()
+		assert.NoError(t, err)
+		defer txn.Rollback()
+
+		err = txn.Delete(second)
+		assert.True(t, errors.Is(err, ErrStaleVersion))
+
+		err = txn.Delete(first)
+		assert.NoError(t, err)
+	})
+}
+
+func TestTransaction_ForeignKeys(t *testing.T) {
+	type Category struct {
+		CategoryId uint64 `m:"pk"`
+		ParentId   uint64
+		Parent     *Category `m:"fk:ParentId"`
+		Name       string
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	t.Run("same insert", func(t *testing.T) {
+		// A parent of 0 does not reference anything, and the child references a record that is
+		// inserted with it.
+		err := txn.Insert([]Category{
+			{CategoryId: 2, ParentId: 1, Name: "child"},
+			{CategoryId: 1, Name: "root"},
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("missing parent", func(t *testing.T) {
+		err := txn.Insert([]Category{
+			{CategoryId: 3, ParentId: 1},
+			{CategoryId: 4, ParentId: 9},
+		})
+		assert.Equal(t, &RowError{
+			Row: 1,
+			Err: &ForeignKeyViolation{
+				Model:      "Category",
+				ForeignKey: "ParentId",
+				References: "Category",
+				Values:     []interface{}{uint64(9)},
+			},
+		}, err)
+
+		err = txn.Update(Category{CategoryId: 2, ParentId: 9})
+		var violation *ForeignKeyViolation
+		assert.True(t, errors.As(err, &violation))
+	})
+
+	t.Run("unchanged parent", func(t *testing.T) {
+		err := txn.Update(Category{CategoryId: 2, ParentId: 1, Name: "renamed"})
+		assert.NoError(t, err)
+	})
+}
+
+func TestTransaction_Errors(t *testing.T) {
+	type Team struct {
+		TeamId uint64 `m:"pk"`
+		Name   string
+	}
+
+	type Member struct {
+		MemberId uint64 `m:"pk"`
+		TeamId   *uint64
+		Team     Team   `m:"fk:TeamId"`
+		Email    string `m:"uq"`
+		Region   string `m:"uq:uq_region_name"`
+		Name     string `m:"uq:uq_region_name"`
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+
+	teamId := uint64(1)
+	err = txn.Insert(Team{TeamId: teamId, Name: "one"})
+	assert.NoError(t, err)
+
+	err = txn.Insert(Member{MemberId: 1, TeamId: &teamId, Email: "one@example.com", Region: "east", Name: "one"})
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Commit())
+
+	txn, err = db.Begin()
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	t.Run("primary key", func(t *testing.T) {
+		err := txn.Insert(Member{MemberId: 1, Email: "two@example.com"})
+		assert.EqualError(t, err, "Member already has a record with primary key (1)")
+
+		var violation *PrimaryKeyViolation
+		assert.True(t, errors.As(err, &violation))
+	})
+
+	t.Run("unique", func(t *testing.T) {
+		err := txn.Insert(Member{MemberId: 2, Email: "two@example.com", Region: "east", Name: "one"})
+		assert.EqualError(t, err, `Member unique constraint [uq_region_name] already has a record with ("east","one")`)
+
+		var violation *UniqueViolation
+		if assert.True(t, errors.As(err, &violation)) {
+			assert.Equal(t, "Member", violation.Model)
+			assert.Equal(t, "uq_region_name", violation.Constraint)
+			assert.Equal(t, []interface{}{"east", "one"}, violation.Values)
+		}
+
+		err = txn.Insert(Member{MemberId: 2, Email: "two@example.com", Region: "west", Name: "two"})
+		assert.NoError(t, err)
+
+		err = txn.Update(Member{MemberId: 2, Email: "one@example.com", Region: "west", Name: "two"})
+		assert.Equal(t, &UniqueViolation{
+			Model:      "Member",
+			Constraint: "uq_email",
+			Values:     []interface{}{"one@example.com"},
+		}, err)
+	})
+
+	t.Run("foreign key", func(t *testing.T) {
+		missingTeamId := uint64(2)
+		err := txn.Insert(Member{MemberId: 3, TeamId: &missingTeamId, Email: "three@example.com"})
+		assert.EqualError(t, err, "Member field [TeamId] references a Team record with primary key (2) that does not exist")
+
+		var violation *ForeignKeyViolation
+		if assert.True(t, errors.As(err, &violation)) {
+			assert.Equal(t, "Team", violation.References)
+		}
+
+		err = txn.Update(Member{MemberId: 2, TeamId: &missingTeamId, Email: "two@example.com", Region: "west", Name: "two"})
+		assert.True(t, errors.As(err, &violation))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		err := txn.Update(Member{MemberId: 4})
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.EqualError(t, err, "record not found: Member with primary key (4)")
+
+		err = txn.Delete(Member{MemberId: 4})
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		first, err := db.Begin()
+		assert.NoError(t, err)
+
+		second, err := db.Begin()
+		assert.NoError(t, err)
+
+		err = first.Update(Team{TeamId: 1, Name: "first"})
+		assert.NoError(t, err)
+
+		err = second.Update(Team{TeamId: 1, Name: "second"})
+		assert.NoError(t, err)
+
+		assert.NoError(t, first.Commit())
+		assert.Equal(t, ErrConflict, second.Commit())
+	})
+}
+
+func TestTransaction_InsertBatch(t *testing.T) {
+	type Member struct {
+		MemberId uint64 `m:"pk"`
+		Email    string `m:"uq"`
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	t.Run("duplicate primary key", func(t *testing.T) {
+		err := txn.Insert([]Member{
+			{MemberId: 1, Email: "one@example.com"},
+			{MemberId: 2, Email: "two@example.com"},
+			{MemberId: 1, Email: "three@example.com"},
+		})
+		assert.EqualError(t, err, "rows 0 and 2 of the Member batch have the same primary key (1)")
+	})
+
+	t.Run("duplicate unique values", func(t *testing.T) {
+		err := txn.Insert([]Member{
+			{MemberId: 1, Email: "one@example.com"},
+			{MemberId: 2, Email: "two@example.com"},
+			{MemberId: 3, Email: "two@example.com"},
+		})
+
+		var duplicate *BatchDuplicate
+		if assert.True(t, errors.As(err, &duplicate)) {
+			assert.Equal(t, &BatchDuplicate{
+				Model:      "Member",
+				Constraint: "uq_email",
+				Rows:       []int{1, 2},
+				Values:     []interface{}{"two@example.com"},
+			}, duplicate)
+		}
+		assert.EqualError(t, err, `rows 1 and 2 of the Member batch have the same values ("two@example.com") for unique constraint [uq_email]`)
+	})
+
+	t.Run("nothing is written", func(t *testing.T) {
+		results := make([]Member, 0)
+		err := txn.Model(results).Select(&results)
+		assert.NoError(t, err)
+		assert.Empty(t, results)
+	})
+}
+
+func TestTransaction_ReadOnly(t *testing.T) {
+	type Report struct {
+		ReportId uint64 `m:"pk"`
+		Title    string
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Insert(Report{ReportId: 1, Title: "first"}))
+	assert.NoError(t, txn.Commit())
+
+	// The timestamp of the snapshot must be after the first commit.
+	time.Sleep(10 * time.Millisecond)
+	snapshot := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	txn, err = db.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Update(&Report{ReportId: 1, Title: "second"}))
+	assert.NoError(t, txn.Commit())
+
+	getTitles := func(txn *Transaction) []string {
+		reports := make([]Report, 0)
+		assert.NoError(t, txn.Model(reports).Select(&reports))
+		titles := make([]string, len(reports))
+		for i, report := range reports {
+			titles[i] = report.Title
+		}
+
+		return titles
+	}
+
+	t.Run("rejects writes", func(t *testing.T) {
+		txn, err := db.BeginReadOnly()
+		assert.NoError(t, err)
+		defer txn.Rollback()
+		assert.True(t, txn.ReadOnly())
+
+		err = txn.Insert(Report{ReportId: 2, Title: "other"})
+		assert.True(t, errors.Is(err, ErrReadOnly))
+		assert.EqualError(t, err, "transaction is read only: cannot insert Report")
+
+		err = txn.Update(&Report{ReportId: 1, Title: "other"})
+		assert.True(t, errors.Is(err, ErrReadOnly))
+
+		err = txn.Delete(&Report{ReportId: 1})
+		assert.True(t, errors.Is(err, ErrReadOnly))
+
+		assert.Equal(t, []string{"second"}, getTitles(txn))
+	})
+
+	t.Run("does not conflict", func(t *testing.T) {
+		readTxn, err := db.BeginReadOnly()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"second"}, getTitles(readTxn))
+
+		txn, err := db.Begin()
+		assert.NoError(t, err)
+		assert.NoError(t, txn.Update(&Report{ReportId: 1, Title: "third"}))
+		assert.NoError(t, txn.Commit())
+
+		assert.Equal(t, []string{"second"}, getTitles(readTxn))
+		assert.NoError(t, readTxn.Commit())
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		txn, err := db.BeginReadOnlyAt(snapshot)
+		assert.NoError(t, err)
+		defer txn.Rollback()
+
+		assert.Equal(t, []string{"first"}, getTitles(txn))
+	})
+}
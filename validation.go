@@ -0,0 +1,229 @@
+package mellivora
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// validationRule is a check declared by a tag on a field. The check returns a message describing
+// the problem if the value of the field is not valid, or an empty string if it is.
+type validationRule struct {
+	tag   string
+	check func(value reflect.Value) string
+}
+
+// newValidationRule parses the validation tag for a field of the provided type. Rules other than
+// notempty are not checked when the field is a nil pointer.
+func newValidationRule(key, tagValue string, typ reflect.Type) (*validationRule, error) {
+	base := typ
+	for base.Kind() == reflect.Ptr {
+		base = base.Elem()
+	}
+
+	rule := &validationRule{
+		tag: key,
+	}
+	if len(tagValue) > 0 {
+		rule.tag = fmt.Sprintf("%s:%s", key, tagValue)
+	}
+
+	var check func(value reflect.Value) string
+	switch key {
+	case "notempty":
+		rule.check = func(value reflect.Value) string {
+			if isNullValue(value) || isEmptyValue(value) {
+				return "must not be empty"
+			}
+
+			return ""
+		}
+
+		return rule, nil
+	case "min", "max":
+		if !isNumericKind(base.Kind()) {
+			return nil, fmt.Errorf("%s can only be used on numbers, not %s", key, typ)
+		}
+
+		bound, err := parseTagValue(tagValue, base)
+		if err != nil {
+			return nil, fmt.Errorf("%s %v", key, err)
+		}
+
+		check = func(value reflect.Value) string {
+			switch comparison := compareNumbers(value, bound); {
+			case key == "min" && comparison < 0:
+				return fmt.Sprintf("must be at least %s", tagValue)
+			case key == "max" && comparison > 0:
+				return fmt.Sprintf("must be at most %s", tagValue)
+			default:
+				return ""
+			}
+		}
+	case "maxlen":
+		switch base.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map:
+		default:
+			return nil, fmt.Errorf("maxlen can only be used on strings, slices and maps, not %s", typ)
+		}
+
+		maxLength, err := strconv.Atoi(tagValue)
+		if err != nil || maxLength < 0 {
+			return nil, fmt.Errorf("maxlen must be a length, not [%s]", tagValue)
+		}
+
+		check = func(value reflect.Value) string {
+			length := value.Len()
+			if value.Kind() == reflect.String {
+				length = utf8.RuneCountInString(value.String())
+			}
+
+			if length > maxLength {
+				return fmt.Sprintf("must not be longer than %d, got %d", maxLength, length)
+			}
+
+			return ""
+		}
+	case "regex":
+		if base.Kind() != reflect.String {
+			return nil, fmt.Errorf("regex can only be used on strings, not %s", typ)
+		}
+
+		expression, err := regexp.Compile(tagValue)
+		if err != nil {
+			return nil, fmt.Errorf("regex is not valid: %v", err)
+		}
+
+		check = func(value reflect.Value) string {
+			if !expression.MatchString(value.String()) {
+				return fmt.Sprintf("must match %s", tagValue)
+			}
+
+			return ""
+		}
+	case "oneof":
+		options := strings.Split(tagValue, "|")
+		allowed := make([]reflect.Value, len(options))
+		for i, option := range options {
+			parsed, err := parseTagValue(option, base)
+			if err != nil {
+				return nil, fmt.Errorf("oneof %v", err)
+			}
+			allowed[i] = parsed
+		}
+
+		check = func(value reflect.Value) string {
+			for _, option := range allowed {
+				if reflect.DeepEqual(value.Interface(), option.Interface()) {
+					return ""
+				}
+			}
+
+			return fmt.Sprintf("must be one of [%s]", strings.Join(options, ", "))
+		}
+	default:
+		return nil, fmt.Errorf("[%s] is not a validation rule", key)
+	}
+
+	rule.check = func(value reflect.Value) string {
+		for value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return ""
+			}
+			value = value.Elem()
+		}
+
+		return check(value)
+	}
+
+	return rule, nil
+}
+
+// validateRecord checks every validation rule of the model's fields against the record. If any of
+// the rules are not met then a ValidationErrors is returned with each of the problems.
+func validateRecord(model Model, record reflect.Value) error {
+	var errs ValidationErrors
+	for _, field := range model.Fields().GetAll() {
+		for _, rule := range field.(*modelField).rules {
+			if message := rule.check(record.FieldByIndex(field.Reflection().Index)); message != "" {
+				errs = append(errs, &ValidationError{
+					Model:   model.Name(),
+					Field:   field.Name(),
+					Rule:    rule.tag,
+					Message: message,
+				})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// isEmptyValue returns true if a string, slice or map has no items, or if any other value is the
+// zero value of its type.
+func isEmptyValue(value reflect.Value) bool {
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return true
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	default:
+		return isZeroValue(value)
+	}
+}
+
+func isNumericKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// compareNumbers returns -1, 0 or 1 if a is less than, equal to or greater than b. Both values must
+// be the same kind of number.
+func compareNumbers(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, y := a.Int(), b.Int()
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, y := a.Uint(), b.Uint()
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	default:
+		x, y := a.Float(), b.Float()
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+
+	return 0
+}
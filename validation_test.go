@@ -0,0 +1,87 @@
+package mellivora
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTransaction_Validation(t *testing.T) {
+	type Server struct {
+		ServerId uint64  `m:"pk"`
+		Host     string  `m:"notempty,maxlen:16"`
+		Port     int32   `m:"min:1,max:65535"`
+		Protocol string  `m:"oneof:tcp|udp,default:tcp"`
+		Name     *string `m:"regex:^[a-z]{1,3}(-[0-9]{1,2})?$"`
+		Tags     []string
+	}
+
+	db, cleanup := NewTestDatabase(t)
+	defer cleanup()
+
+	txn, err := db.Begin()
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	t.Run("valid", func(t *testing.T) {
+		name := "web-1"
+		err := txn.Insert([]Server{
+			{ServerId: 1, Host: "localhost", Port: 80},
+			{ServerId: 2, Host: "127.0.0.1", Port: 53, Protocol: "udp", Name: &name},
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("every field", func(t *testing.T) {
+		name := "web-100"
+		err := txn.Insert(Server{
+			ServerId: 3,
+			Host:     "",
+			Port:     70000,
+			Protocol: "http",
+			Name:     &name,
+		})
+		assert.EqualError(t, err, "Server field [Host] must not be empty; "+
+			"Server field [Port] must be at most 65535; "+
+			"Server field [Protocol] must be one of [tcp, udp]; "+
+			"Server field [Name] must match ^[a-z]{1,3}(-[0-9]{1,2})?$")
+
+		validationErrs, ok := err.(ValidationErrors)
+		if assert.True(t, ok) {
+			assert.Equal(t, "notempty", validationErrs[0].Rule)
+			assert.Equal(t, "max:65535", validationErrs[1].Rule)
+		}
+	})
+
+	t.Run("before any keys are written", func(t *testing.T) {
+		err := txn.Insert([]Server{
+			{ServerId: 4, Host: "localhost", Port: 80},
+			{ServerId: 5, Host: "a very long host name", Port: 0},
+		})
+		assert.EqualError(t, err, "row 1: Server field [Host] must not be longer than 16, got 21; "+
+			"Server field [Port] must be at least 1")
+
+		var rowErr *RowError
+		if assert.True(t, errors.As(err, &rowErr)) {
+			assert.Equal(t, 1, rowErr.Row)
+		}
+
+		var validationErrs ValidationErrors
+		if assert.True(t, errors.As(err, &validationErrs)) {
+			assert.Len(t, validationErrs, 2)
+		}
+
+		results := make([]Server, 0)
+		err = txn.Model(results).Where(Ex{"ServerId": 4}).Select(&results)
+		assert.NoError(t, err)
+		assert.Empty(t, results)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		err := txn.Update(&Server{ServerId: 1, Host: "localhost", Port: 0, Protocol: "tcp"})
+		assert.EqualError(t, err, "Server field [Port] must be at least 1")
+
+		err = txn.Update(&Server{ServerId: 1, Host: "localhost", Port: 8080, Protocol: "tcp"})
+		assert.NoError(t, err)
+	})
+}